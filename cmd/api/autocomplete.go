@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+func (app *application) autocompleteMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Query string
+		Limit int
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	// The endpoint is called as the user types, so we keep each request cheap by limiting the length of the term and the number of
+	// suggestions. Like every other endpoint, it is also subject to the per-client rate limiter.
+	input.Query = app.readString(qs, "q", "")
+	input.Limit = app.readInt(qs, "limit", 10, v)
+
+	v.Check(input.Query != "", "q", "must be provided")
+	v.Check(len(input.Query) <= 100, "q", "must not be more than 100 bytes long")
+	v.Check(input.Limit > 0, "limit", "must be greater than zero")
+	v.Check(input.Limit <= 20, "limit", "must be a maximum of 20")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Movies.Autocomplete(input.Query, input.Limit, app.movieVisibility(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+)
+
+// The movieETag() helper returns a strong entity tag for a movie. The version number is incremented every time the movie is edited, and the
+// rating aggregates change when its reviews do, so together they identify the representation.
+func movieETag(movie *data.Movie) string {
+	return fmt.Sprintf(`"%d-%d-%.2f"`, movie.Version, movie.RatingsCount, movie.AverageRating)
+}
+
+// The movieListETag() helper returns a weak entity tag for a page of movies, computed from the IDs and entity tags of the movies in the result set
+// along with the pagination metadata (so that, for example, a change to the total number of records also changes the tag), the facet counts
+// and any sparse fieldset.
+func movieListETag(movies []*data.Movie, metadata data.Metadata, aggregations data.Aggregations, fields []string) string {
+	hash := sha256.New()
+
+	for _, movie := range movies {
+		fmt.Fprintf(hash, "%d:%s,", movie.ID, movieETag(movie))
+	}
+	fmt.Fprintf(hash, "%d:%d:%s:%s", metadata.TotalRecords, metadata.LastPage, metadata.NextCursor, strings.Join(fields, ","))
+
+	// Maps are encoded with their keys in sorted order, so the facets always hash to the same value.
+	json.NewEncoder(hash).Encode(aggregations)
+
+	return fmt.Sprintf(`W/"%x"`, hash.Sum(nil)[:16])
+}
+
+// The movieListLastModified() helper returns the time that the most recently updated movie in a list was last modified, or the zero time if
+// the list is empty.
+func movieListLastModified(movies []*data.Movie) time.Time {
+	var lastModified time.Time
+
+	for _, movie := range movies {
+		if movie.UpdatedAt.After(lastModified) {
+			lastModified = movie.UpdatedAt
+		}
+	}
+
+	return lastModified
+}
+
+// The movieCreditsETag() helper returns a weak entity tag for a movie along with its credits, computed from the entity tag of the movie and the
+// credits themselves.
+func movieCreditsETag(etag string, credits []*data.Credit) string {
+	hash := sha256.New()
+
+	fmt.Fprintf(hash, "%s:", etag)
+	json.NewEncoder(hash).Encode(credits)
+
+	return fmt.Sprintf(`W/"%x"`, hash.Sum(nil)[:16])
+}
+
+// The checkNotModified() helper adds the ETag and Last-Modified headers to the response and checks them against the If-None-Match and
+// If-Modified-Since headers of the request. If the client's copy is still current, it sends a 304 Not Modified response and returns true.
+func (app *application) checkNotModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since. It uses the weak comparison function, so the W/ prefix is ignored.
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		for _, tag := range strings.Split(header, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+		return false
+	}
+
+	// HTTP dates only have a precision of one second, so we truncate the last modified time before comparing it.
+	if header := r.Header.Get("If-Modified-Since"); header != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(header)
+		if err == nil && !lastModified.Truncate(time.Second).After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+// The checkIfMatch() helper checks the If-Match header of a write request against the current entity tag of the resource. It returns false,
+// after sending a 412 Precondition Failed response, if none of the tags match. If there is no If-Match header the request is allowed unless
+// the server has been configured to require one, in which case a 428 Precondition Required response is sent and it returns false.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+
+	if header == "" {
+		if app.config.conditional.requireIfMatch {
+			app.preconditionRequiredResponse(w, r)
+			return false
+		}
+		return true
+	}
+
+	// A "*" matches any current representation of the resource. Otherwise the header is a comma-separated list of entity tags,
+	// which must be compared using the strong comparison function, so weak tags never match.
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || (tag == etag && !strings.HasPrefix(tag, "W/")) {
+			return true
+		}
+	}
+
+	app.preconditionFailedResponse(w, r)
+	return false
+}
@@ -14,6 +14,9 @@ type contextKey string
 // We'll use this constant as the key for getting and setting user information in the request context.
 const userContextKey = contextKey("user")
 
+// The permissionsContextKey is used for the current user's permissions, which are added to the context by the requirePermission() middleware.
+const permissionsContextKey = contextKey("permissions")
+
 // The contextSetUser() method returns a new copy of the request with the provided User struct added to the context.
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -29,3 +32,16 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// The contextSetPermissions() method returns a new copy of the request with the user's permissions added to the context.
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// The contextGetPermissions() method retrieves the user's permissions from the request context. If the requirePermission() middleware
+// hasn't been used for the route, there are no permissions in the context and an empty slice is returned.
+func (app *application) contextGetPermissions(r *http.Request) data.Permissions {
+	permissions, _ := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions
+}
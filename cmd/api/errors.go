@@ -3,6 +3,9 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strings"
+
+	"greenlight.alexedwards.net/internal/data"
 )
 
 // The logError() method is a generic helper for logging an error message.
@@ -82,6 +85,35 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been modified since the version given in the If-Match header"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this request must include an If-Match header"
+	app.errorResponse(w, r, http.StatusPreconditionRequired, message)
+}
+
+// The unsupportedMediaTypeResponse() method will be used to send a 415 Unsupported Media Type status code and JSON response to the client,
+// listing the media types that the endpoint accepts.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, supported ...string) {
+	message := fmt.Sprintf("the Content-Type header must be one of: %s", strings.Join(supported, ", "))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+// The duplicateMovieResponse() method will be used to send a 409 Conflict status code and JSON response to the client when a new movie looks
+// like a duplicate, listing the existing movies which it matches.
+func (app *application) duplicateMovieResponse(w http.ResponseWriter, r *http.Request, duplicates []*data.Movie) {
+	message := "a movie with the same title and year already exists, so it has not been created; use force=true to create it anyway"
+
+	err := app.writeJSON(w, http.StatusConflict, envelope{"error": message, "duplicates": duplicates}, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+
 // The methodNotAllowedResponse() method will be used to send a 405 Method Not Allowed status code and JSON response to the client.
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
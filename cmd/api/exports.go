@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// The number of rows written between each flush of the response during an export.
+const exportFlushInterval = 100
+
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.MovieFilters
+		Format string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	// Read the same filter and sort parameters as listMoviesHandler(). There is no pagination, so page and page_size aren't used.
+	input.MovieFilters = app.readMovieFilters(r, v)
+
+	input.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = movieSortSafelist
+
+	input.Format = app.readString(qs, "format", "ndjson")
+	v.Check(validator.In(input.Format, "ndjson", "csv"), "format", "invalid format value")
+
+	data.ValidateMovieFilters(v, input.MovieFilters)
+	data.ValidateSort(v, input.Filters)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// An export can take longer than the server's write timeout, so we remove the deadline for this response.
+	rc := http.NewResponseController(w)
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The writeRow() and flush() functions are set up for the requested format below.
+	var writeRow func(*data.Movie) error
+	var flush func() error
+
+	switch input.Format {
+	case "csv":
+		cw := csv.NewWriter(w)
+
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.csv"`)
+
+		// Runtimes are written in the same "<runtime> mins" format as the JSON API, and genres are comma-separated within their field.
+		writeRow = func(movie *data.Movie) error {
+			return cw.Write([]string{
+				strconv.FormatInt(movie.ID, 10),
+				movie.Title,
+				strconv.FormatInt(int64(movie.Year), 10),
+				movie.Runtime.String(),
+				strings.Join(movie.Genres, ","),
+				strconv.FormatInt(int64(movie.Version), 10),
+			})
+		}
+
+		// The CSV writer has its own buffer, which needs to be flushed before the response.
+		flush = func() error {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+
+		err = cw.Write([]string{"id", "title", "year", "runtime", "genres", "version"})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	default:
+		enc := json.NewEncoder(w)
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.ndjson"`)
+
+		// The json.Encoder writes a newline after every value, which is exactly what we need for NDJSON.
+		writeRow = func(movie *data.Movie) error {
+			return enc.Encode(movie)
+		}
+		flush = rc.Flush
+	}
+
+	// Stream the rows straight from the database to the response, flushing regularly so that the client receives them as we go.
+	// If the client disconnects, the request context is cancelled and the query stops.
+	rows := 0
+
+	err = app.models.Movies.Export(r.Context(), input.MovieFilters, input.Filters, func(movie *data.Movie) error {
+		err := writeRow(movie)
+		if err != nil {
+			return err
+		}
+
+		rows++
+		if rows%exportFlushInterval == 0 {
+			return flush()
+		}
+
+		return nil
+	})
+	if err != nil {
+		switch {
+		// A cancelled context means that the client went away, so there is nobody to respond to and nothing we need to log.
+		case errors.Is(err, context.Canceled):
+		// If no rows have been written yet, we can still send an error response.
+		case rows == 0:
+			app.serverErrorResponse(w, r, err)
+		// Otherwise the response has already started, so all we can do is log the error and stop.
+		default:
+			app.logError(r, err)
+		}
+		return
+	}
+
+	err = flush()
+	if err != nil && !errors.Is(err, context.Canceled) {
+		app.logError(r, err)
+	}
+}
@@ -9,8 +9,10 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"greenlight.alexedwards.net/internal/data"
 	"greenlight.alexedwards.net/internal/validator"
 )
 
@@ -31,6 +33,46 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// The readVersionParam() helper retrieves the "version" URL parameter from the current request context in the same way as readIDParam().
+func (app *application) readVersionParam(r *http.Request) (int32, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	version, err := strconv.ParseInt(params.ByName("version"), 10, 32)
+	if err != nil || version < 1 {
+		return 0, errors.New("invalid version parameter")
+	}
+	return int32(version), nil
+}
+
+// The readReviewIDParam() helper retrieves the "review_id" URL parameter in the same way as readIDParam().
+func (app *application) readReviewIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName("review_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid review_id parameter")
+	}
+	return id, nil
+}
+
+// The readCreditIDParam() helper retrieves the "credit_id" URL parameter in the same way as readIDParam().
+func (app *application) readCreditIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName("credit_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid credit_id parameter")
+	}
+	return id, nil
+}
+
+// The readSlugParam() helper retrieves the "slug" URL parameter from the current request context.
+func (app *application) readSlugParam(r *http.Request) string {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	return params.ByName("slug")
+}
+
 // Define an envelope type.
 type envelope map[string]interface{}
 
@@ -61,6 +103,36 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	return nil
 }
 
+// The pickFields() helper narrows a value down to a sparse fieldset before it is sent to the client. It encodes the value to JSON and then
+// keeps only the keys named in fields (any keys which were omitted, for example because of an omitempty directive, stay omitted).
+// If no fields are given the value is returned unchanged.
+func (app *application) pickFields(value interface{}, fields []string) (interface{}, error) {
+	if len(fields) == 0 {
+		return value, nil
+	}
+
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var all map[string]json.RawMessage
+
+	err = json.Unmarshal(js, &all)
+	if err != nil {
+		return nil, err
+	}
+
+	picked := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		if value, ok := all[field]; ok {
+			picked[field] = value
+		}
+	}
+
+	return picked, nil
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 	// Use http.MaxBytesReader() to limit the size of the request body to 1MB.
 	maxBytes := 1_048_576
@@ -74,55 +146,60 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	// Decode the request body into the target destination.
 	err := dec.Decode(dst)
 	if err != nil {
-		// If there is an error during decoding, start the triage...
-		var syntaxError *json.SyntaxError
-		var unmarshalTypeError *json.UnmarshalTypeError
-		var invalidUnmarshalError *json.InvalidUnmarshalError
-		switch {
-		// Use the errors.As() function to check whether the error has the type *json.SyntaxError.
-		// If it does, then return a plain-english error message which includes the location of the problem.
-		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
-
-		// In some circumstances Decode() may also return an io.ErrUnexpectedEOF error for syntax errors in the JSON.
-		// So we check for this using errors.Is() and return a generic error message.
-		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains badly-formed JSON")
-
-		// Likewise, catch any *json.UnmarshalTypeError errors. These occur when the JSON value is the wrong type for the target destination.
-		// If the error relates to a specific field, then we include that in our error message to make it easier for the client to debug.
-		case errors.As(err, &unmarshalTypeError):
-			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
-			}
-			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
-
-		// An io.EOF error will be returned by Decode() if the request body is empty.
-		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
+		return app.triageJSONError(err, maxBytes)
+	}
 
-		// If the JSON contains a field which cannot be mapped to the target destination then Decode() will now return an error message in the format
-		// "json: unknown field "<name>"". We check for this, extract the field name from the error, and interpolate it into our custom error message.
-		case strings.HasPrefix(err.Error(), "json: unknown field "):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
+	return nil
+}
 
-		// If the request body exceeds 1MB in size the decode will now fail with the error "http: request body too large".
-		case err.Error() == "http: request body too large":
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+// The triageJSONError() helper converts an error returned by a json.Decoder into a plain-english error message that can be sent to the client.
+func (app *application) triageJSONError(err error, maxBytes int) error {
+	// If there is an error during decoding, start the triage...
+	var syntaxError *json.SyntaxError
+	var unmarshalTypeError *json.UnmarshalTypeError
+	var invalidUnmarshalError *json.InvalidUnmarshalError
+	switch {
+	// Use the errors.As() function to check whether the error has the type *json.SyntaxError.
+	// If it does, then return a plain-english error message which includes the location of the problem.
+	case errors.As(err, &syntaxError):
+		return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
 
-		// A json.InvalidUnmarshalError error will be returned if we pass a non-nil pointer to Decode().
-		// We catch this and panic, rather than returning an error to our handler.
-		case errors.As(err, &invalidUnmarshalError):
-			panic(err)
+	// In some circumstances Decode() may also return an io.ErrUnexpectedEOF error for syntax errors in the JSON.
+	// So we check for this using errors.Is() and return a generic error message.
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return errors.New("body contains badly-formed JSON")
 
-		// For anything else, return the error message as-is.
-		default:
-			return err
+	// Likewise, catch any *json.UnmarshalTypeError errors. These occur when the JSON value is the wrong type for the target destination.
+	// If the error relates to a specific field, then we include that in our error message to make it easier for the client to debug.
+	case errors.As(err, &unmarshalTypeError):
+		if unmarshalTypeError.Field != "" {
+			return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
 		}
-	}
+		return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
 
-	return nil
+	// An io.EOF error will be returned by Decode() if the request body is empty.
+	case errors.Is(err, io.EOF):
+		return errors.New("body must not be empty")
+
+	// If the JSON contains a field which cannot be mapped to the target destination then Decode() will now return an error message in the format
+	// "json: unknown field "<name>"". We check for this, extract the field name from the error, and interpolate it into our custom error message.
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		return fmt.Errorf("body contains unknown key %s", fieldName)
+
+	// If the request body exceeds the maximum size the decode will now fail with the error "http: request body too large".
+	case err.Error() == "http: request body too large":
+		return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+
+	// A json.InvalidUnmarshalError error will be returned if we pass a non-nil pointer to Decode().
+	// We catch this and panic, rather than returning an error to our handler.
+	case errors.As(err, &invalidUnmarshalError):
+		panic(err)
+
+	// For anything else, return the error message as-is.
+	default:
+		return err
+	}
 }
 
 // The readString() helper returns a string value from the query string, or the provided default value if no matching key could be found.
@@ -172,3 +249,76 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	// Otherwise, return the converted integer value.
 	return i
 }
+
+// The readTime() helper reads a timestamp from the query string. Both RFC 3339 timestamps and plain dates (which are treated as midnight UTC)
+// are accepted. If the value couldn't be parsed, then we record an error message in the provided Validator instance and return the zero time.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return time.Time{}
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t
+		}
+	}
+
+	v.AddError(key, "must be an RFC 3339 timestamp or a date in YYYY-MM-DD format")
+	return time.Time{}
+}
+
+// The readBool() helper reads a string value from the query string and converts it to a boolean before returning.
+// If the value couldn't be converted, then we record an error message in the provided Validator instance.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+// The movieVisibility() helper returns the movies which the current user can see, based on the permissions added to the request context by
+// requirePermission(). Users with movies:write can see every movie, and users with movies:write:own can also see their own unpublished movies.
+func (app *application) movieVisibility(r *http.Request) data.Visibility {
+	permissions := app.contextGetPermissions(r)
+
+	switch {
+	case permissions.Include("movies:write"):
+		return data.Visibility{All: true}
+	case permissions.Include("movies:write:own"):
+		return data.Visibility{Owner: app.contextGetUser(r).ID}
+	default:
+		return data.Visibility{}
+	}
+}
+
+// The checkMovieVisible() helper checks that a movie exists and is visible to the current user, before a handler reads or changes one of the
+// movie's subresources, such as its reviews or credits. If not, it sends a 404 Not Found response and returns false. GET requests for a movie
+// which has been merged into another one are redirected to the same subresource of the other movie instead.
+func (app *application) checkMovieVisible(w http.ResponseWriter, r *http.Request, id int64) bool {
+	_, err := app.models.Movies.Get(id, app.movieVisibility(r), "id")
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			if r.Method != http.MethodGet || !app.redirectMergedMovie(w, r, id) {
+				app.notFoundResponse(w, r)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+)
+
+// The maximum length of an Idempotency-Key header. Clients will normally send a UUID.
+const maxIdempotencyKeyLength = 255
+
+// The idempotent() middleware makes it safe for clients to retry a POST request. If the request has an Idempotency-Key header, the response
+// is stored along with a fingerprint of the request, and any repeat of the request with the same key gets the stored response instead of
+// running the handler again. Reusing a key for a different request is an error, and a request which arrives while another request with the
+// same key is being processed waits for it to finish. Keys are scoped to the user, and anonymous requests share the same scope.
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			app.badRequestResponse(w, r, fmt.Errorf("Idempotency-Key header must not be more than %d bytes long", maxIdempotencyKeyLength))
+			return
+		}
+
+		// Read the body, so that it can be included in the fingerprint, and replace it with a copy for the handler. The size limit is the
+		// same as the one used by readJSON().
+		maxBytes := 1_048_576
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				err = fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+			}
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// The fingerprint covers the method and path as well as the body, so that a key can't be reused for a different endpoint.
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.RequestURI())
+		hash.Write(body)
+
+		claim, err := app.models.Idempotency.Claim(app.contextGetUser(r).ID, key, hash.Sum(nil), app.config.idempotency.ttl)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrIdempotencyKeyReused):
+				app.errorResponse(w, r, http.StatusUnprocessableEntity, "the Idempotency-Key header has already been used for a different request")
+			case errors.Is(err, data.ErrIdempotencyKeyLocked):
+				app.errorResponse(w, r, http.StatusConflict, "a request with the same Idempotency-Key header is still being processed")
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if claim.Response != nil {
+			for name, values := range claim.Response.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(claim.Response.Status)
+			w.Write(claim.Response.Body)
+			return
+		}
+
+		// Unless the response is saved, release the key so that the request can be retried. This also happens if the handler panics.
+		keep := false
+		defer func() {
+			if !keep {
+				err := claim.Release()
+				if err != nil {
+					app.logError(r, err)
+				}
+			}
+		}()
+
+		// The response is held back until it has been saved, so that the client never receives a response which can't be replayed.
+		rec := &responseRecorder{ResponseWriter: w}
+
+		next(rec, r)
+
+		// Server errors aren't stored, so the request can be retried.
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+			rec.flush()
+			return
+		}
+
+		// If the response can't be saved, the client gets an error instead. The key stays marked as being processed until its lease expires,
+		// so an immediate retry can't repeat the request's changes.
+		keep = true
+		err = claim.Save(&data.IdempotentResponse{Status: rec.status, Header: rec.Header(), Body: rec.body.Bytes()})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		rec.flush()
+	}
+}
+
+// The responseRecorder type wraps a http.ResponseWriter, recording the status, headers and body of the response instead of sending them.
+// Calling flush() sends the recorded response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	if rec.header == nil {
+		rec.header = make(http.Header)
+	}
+	return rec.header
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	return rec.body.Write(b)
+}
+
+// The Unwrap() method returns the original http.ResponseWriter, so that http.ResponseController can reach it.
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// The flush() method sends the recorded response, if there is one.
+func (rec *responseRecorder) flush() {
+	if rec.status == 0 {
+		return
+	}
+
+	for name, values := range rec.header {
+		rec.ResponseWriter.Header()[name] = values
+	}
+	rec.ResponseWriter.WriteHeader(rec.status)
+	rec.ResponseWriter.Write(rec.body.Bytes())
+}
+
+// The purgeIdempotencyKeys() method starts a background job which removes expired idempotency keys every hour. Expired keys are ignored
+// anyway, so this only stops the table from growing.
+func (app *application) purgeIdempotencyKeys() {
+	app.runPeriodically(time.Hour, func() {
+		purged, err := app.models.Idempotency.DeleteExpired()
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		} else if purged > 0 {
+			app.logger.PrintInfo("purged expired idempotency keys", map[string]string{
+				"count": strconv.FormatInt(purged, 10),
+			})
+		}
+	})
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+const (
+	// The maximum size of an import request body (50MB), and of an individual NDJSON line (1MB, the same as readJSON()).
+	maxImportBytes     = 52_428_800
+	maxImportLineBytes = 1_048_576
+
+	// The number of rows that are inserted into the database at a time.
+	importBatchSize = 500
+
+	// In all_or_nothing mode, no movies are inserted if any row fails. In best_effort mode, every valid row is inserted.
+	importModeAllOrNothing = "all_or_nothing"
+	importModeBestEffort   = "best_effort"
+)
+
+// The importResult struct holds the outcome for an individual row in the import report.
+type importResult struct {
+	Row    int               `json:"row"`
+	Status string            `json:"status"` // "created", "failed" or "skipped"
+	ID     int64             `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// The importReport struct is sent back to the client once the import has finished.
+type importReport struct {
+	Mode    string          `json:"mode"`
+	Total   int             `json:"total_rows"`
+	Created int             `json:"created"`
+	Failed  int             `json:"failed"`
+	Rows    []*importResult `json:"rows"`
+}
+
+// An importRowError records the problems with an individual row that couldn't be parsed, keyed by field name.
+type importRowError map[string]string
+
+func (e importRowError) Error() string {
+	return "invalid import row"
+}
+
+// A movieReader returns the next movie from an import each time it is called. It returns io.EOF when there are no more rows, an
+// importRowError if the current row couldn't be parsed, and any other error if the rest of the body can't be read.
+type movieReader func() (*data.Movie, error)
+
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	// Read the import mode from the query string and check that it is one of the supported values.
+	mode := app.readString(r.URL.Query(), "mode", importModeAllOrNothing)
+	if v.Check(validator.In(mode, importModeAllOrNothing, importModeBestEffort), "mode", "invalid mode value"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Use http.MaxBytesReader() to limit the size of the request body. We don't use readJSON() here, as its 1MB limit applies to the whole body.
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	// Pick the reader for the rows based on the Content-Type header.
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var next movieReader
+	var err error
+
+	switch mediaType {
+	case "application/x-ndjson", "application/ndjson":
+		next = app.ndjsonMovieReader(r.Body)
+	case "text/csv":
+		next, err = app.csvMovieReader(r.Body)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	default:
+		app.unsupportedMediaTypeResponse(w, r, "application/x-ndjson", "text/csv")
+		return
+	}
+
+	// Fetch the list of known genres once, rather than for every row.
+	genres, err := app.models.Genres.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	report := &importReport{Mode: mode, Rows: []*importResult{}}
+
+	// The movies' first revisions are recorded against the user making the request.
+	user := app.contextGetUser(r)
+
+	// In all_or_nothing mode, the rows are only inserted once the whole body has been read and every row has passed validation, so that the
+	// transaction isn't held open while the body is streamed. In best_effort mode, each batch is inserted in its own transaction as soon as
+	// it is full.
+	var batch []*data.Movie
+	var batchResults []*importResult
+
+	// The flush() closure inserts the pending movies and records the outcome in the report.
+	flush := func() error {
+		err := app.insertImportRows(r, batch, batchResults, mode, user.ID, report)
+
+		// In best_effort mode, a database error only fails the rows in the current batch which weren't already failed.
+		if err != nil && mode == importModeBestEffort {
+			app.logError(r, err)
+			for _, result := range batchResults {
+				if result.Status == "" {
+					result.Status = "failed"
+					result.Errors = map[string]string{"row": "could not be inserted"}
+					report.Failed++
+				}
+			}
+			err = nil
+		}
+
+		batch, batchResults = nil, nil
+		return err
+	}
+
+	for {
+		movie, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		report.Total++
+		result := &importResult{Row: report.Total}
+		report.Rows = append(report.Rows, result)
+
+		if err != nil {
+			result.Status = "failed"
+			report.Failed++
+
+			// If the row couldn't be parsed, record the errors and move on to the next one.
+			var rowErr importRowError
+			if errors.As(err, &rowErr) {
+				result.Errors = rowErr
+				continue
+			}
+
+			// For any other error the rest of the body can't be read, so we record the error against the current row and stop.
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				err = fmt.Errorf("body must not be larger than %d bytes", maxImportBytes)
+			}
+			result.Errors = map[string]string{"body": err.Error()}
+			break
+		}
+
+		// Validate the movie using the same checks as createMovieHandler().
+		v := validator.New()
+
+		if data.ValidateMovie(v, movie, genres); !v.Valid() {
+			result.Status = "failed"
+			result.Errors = v.Errors
+			report.Failed++
+			continue
+		}
+
+		// Once a row has failed in all_or_nothing mode nothing will be inserted, so there is no point holding on to the remaining rows.
+		if mode == importModeAllOrNothing && report.Failed > 0 {
+			continue
+		}
+
+		batch = append(batch, movie)
+		batchResults = append(batchResults, result)
+
+		if mode == importModeBestEffort && len(batch) == importBatchSize {
+			err = flush()
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+	}
+
+	if mode == importModeBestEffort || report.Failed == 0 {
+		err = flush()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if report.Total == 0 {
+		app.badRequestResponse(w, r, errors.New("body must contain at least one row"))
+		return
+	}
+
+	status := http.StatusCreated
+
+	if mode == importModeAllOrNothing {
+		// If any row failed, nothing was inserted, so we mark all of the valid rows as skipped.
+		if report.Failed > 0 {
+			for _, result := range report.Rows {
+				if result.Status != "failed" {
+					result.Status = "skipped"
+					result.ID = 0
+				}
+			}
+			report.Created = 0
+			status = http.StatusUnprocessableEntity
+		}
+	} else if report.Failed > 0 {
+		status = http.StatusOK
+	}
+
+	err = app.writeJSON(w, status, envelope{"import": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The insertImportRows() helper inserts movies in a single transaction, importBatchSize rows at a time, and records the outcome of each row in
+// the report. A row which can't be inserted, such as one with an IMDb or TMDB ID that is already in use, fails on its own. In all_or_nothing
+// mode the transaction is rolled back as soon as a row fails; otherwise the rows which were inserted are committed.
+func (app *application) insertImportRows(r *http.Request, movies []*data.Movie, results []*importResult, mode string, userID int64, report *importReport) error {
+	if len(movies) == 0 {
+		return nil
+	}
+
+	tx, err := app.models.Movies.BeginImport(userID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+
+	for start := 0; start < len(movies); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(movies) {
+			end = len(movies)
+		}
+
+		rowErrs, err := tx.Insert(movies[start:end])
+		if err != nil {
+			return err
+		}
+
+		for i, rowErr := range rowErrs {
+			if rowErr != nil {
+				result := results[start+i]
+				result.Status = "failed"
+				result.Errors = app.importInsertErrors(r, rowErr)
+				report.Failed++
+			}
+		}
+
+		if mode == importModeAllOrNothing && report.Failed > 0 {
+			return nil
+		}
+	}
+
+	err = tx.Commit()
+	tx = nil
+	if err != nil {
+		return err
+	}
+
+	// Only mark the rows as created once the transaction has been committed.
+	for i, result := range results {
+		if result.Status == "" {
+			result.Status = "created"
+			result.ID = movies[i].ID
+			report.Created++
+		}
+	}
+
+	return nil
+}
+
+// The importInsertErrors() helper converts the error for a row which couldn't be inserted into the errors shown in the import report, using the
+// same messages as createMovieHandler() for duplicate external IDs. Any other error is logged, as it isn't the client's fault.
+func (app *application) importInsertErrors(r *http.Request, err error) map[string]string {
+	switch {
+	case errors.Is(err, data.ErrDuplicateIMDbID):
+		return map[string]string{"imdb_id": "a movie with this IMDb ID already exists"}
+	case errors.Is(err, data.ErrDuplicateTMDbID):
+		return map[string]string{"tmdb_id": "a movie with this TMDB ID already exists"}
+	default:
+		app.logError(r, err)
+		return map[string]string{"row": "could not be inserted"}
+	}
+}
+
+// The ndjsonMovieReader() helper returns a movieReader which decodes one JSON object per line, using the same fields as createMovieHandler().
+// Blank lines are ignored.
+func (app *application) ndjsonMovieReader(body io.Reader) movieReader {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLineBytes)
+
+	return func() (*data.Movie, error) {
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+
+			var input struct {
+				Title   string       `json:"title"`
+				Year    int32        `json:"year"`
+				Runtime data.Runtime `json:"runtime"`
+				Genres  []string     `json:"genres"`
+				IMDbID  string       `json:"imdb_id"`
+				TMDbID  int64        `json:"tmdb_id"`
+			}
+
+			dec := json.NewDecoder(bytes.NewReader(line))
+			dec.DisallowUnknownFields()
+
+			err := dec.Decode(&input)
+			if err != nil {
+				return nil, importRowError{"row": app.triageJSONError(err, maxImportLineBytes).Error()}
+			}
+
+			return &data.Movie{
+				Title:   input.Title,
+				Year:    input.Year,
+				Runtime: input.Runtime,
+				Genres:  input.Genres,
+				IMDbID:  input.IMDbID,
+				TMDbID:  input.TMDbID,
+			}, nil
+		}
+
+		if err := scanner.Err(); err != nil {
+			if errors.Is(err, bufio.ErrTooLong) {
+				return nil, fmt.Errorf("line must not be larger than %d bytes", maxImportLineBytes)
+			}
+			return nil, err
+		}
+
+		return nil, io.EOF
+	}
+}
+
+// The csvMovieReader() helper returns a movieReader for CSV data. The first record must be a header containing the title, year, runtime and genres
+// columns, and optionally the imdb_id and tmdb_id columns (in any order). Runtimes can be given either as a number of minutes or in the "<runtime> mins" format used by the JSON API, and genres
+// are separated by commas within their field.
+func (app *application) csvMovieReader(body io.Reader) (movieReader, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("body must not be empty")
+		}
+		return nil, err
+	}
+
+	// Record the position of each column in the header.
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !validator.In(name, "title", "year", "runtime", "genres", "imdb_id", "tmdb_id") {
+			return nil, fmt.Errorf("header contains unknown column %q", name)
+		}
+		columns[name] = i
+	}
+
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("header must contain a %q column", name)
+		}
+	}
+
+	return func() (*data.Movie, error) {
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, csv.ErrFieldCount) {
+				return nil, importRowError{"row": fmt.Sprintf("must contain %d fields", len(header))}
+			}
+			return nil, err
+		}
+
+		rowErr := importRowError{}
+		movie := &data.Movie{Title: record[columns["title"]]}
+
+		if s := strings.TrimSpace(record[columns["year"]]); s != "" {
+			year, err := strconv.ParseInt(s, 10, 32)
+			if err != nil {
+				rowErr["year"] = "must be an integer value"
+			}
+			movie.Year = int32(year)
+		}
+
+		if s := strings.TrimSpace(record[columns["runtime"]]); s != "" {
+			runtime, err := strconv.ParseInt(s, 10, 32)
+			if err == nil {
+				movie.Runtime = data.Runtime(runtime)
+			} else if movie.Runtime, err = data.ParseRuntime(s); err != nil {
+				rowErr["runtime"] = err.Error()
+			}
+		}
+
+		if i, ok := columns["imdb_id"]; ok {
+			movie.IMDbID = strings.TrimSpace(record[i])
+		}
+
+		if i, ok := columns["tmdb_id"]; ok {
+			if s := strings.TrimSpace(record[i]); s != "" {
+				tmdbID, err := strconv.ParseInt(s, 10, 64)
+				if err != nil {
+					rowErr["tmdb_id"] = "must be an integer value"
+				}
+				movie.TMDbID = tmdbID
+			}
+		}
+
+		for _, genre := range strings.Split(record[columns["genres"]], ",") {
+			if genre = strings.TrimSpace(genre); genre != "" {
+				movie.Genres = append(movie.Genres, genre)
+			}
+		}
+
+		if len(rowErr) > 0 {
+			return nil, rowErr
+		}
+
+		return movie, nil
+	}, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// The runPeriodically() helper launches a background goroutine which runs a job straight away and then once every interval, until the
+// application starts shutting down. serve() waits for the goroutine to finish before it returns, so a job is never cut off part way through.
+func (app *application) runPeriodically(interval time.Duration, job func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			app.runJob(job)
+
+			select {
+			case <-app.shutdown:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// The runJob() helper runs a single run of a background job, recovering any panic so that a problem with one run doesn't bring down the
+// whole application or stop the job from running again.
+func (app *application) runJob(job func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), nil)
+		}
+	}()
+
+	job()
+}
@@ -2,14 +2,19 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"flag"
+	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	_ "github.com/lib/pq"
 	"greenlight.alexedwards.net/internal/data"
 	"greenlight.alexedwards.net/internal/jsonlog"
+	"greenlight.alexedwards.net/internal/storage"
+	"greenlight.alexedwards.net/internal/validator"
 )
 
 // Declare a string containing the application version number.
@@ -32,13 +37,44 @@ type config struct {
 		burst   int
 		enabled bool
 	}
+	// The cursor struct holds the secret key used to sign the pagination cursors we hand out to clients.
+	cursor struct {
+		secret string
+	}
+	// How long movies stay in the trash before they are permanently purged.
+	trash struct {
+		retention time.Duration
+	}
+	// If requireIfMatch is set, writes to existing movies must include an If-Match header.
+	conditional struct {
+		requireIfMatch bool
+	}
+	// The default text search configuration for title searches, and the configurations which are installed in PostgreSQL
+	// (these are loaded from the database when the application starts).
+	search struct {
+		language  string
+		languages []string
+	}
+	// The directory where uploaded posters and their thumbnails are stored.
+	posters struct {
+		dir string
+	}
+	// How long the responses to requests with an Idempotency-Key header are kept, so that they can be replayed.
+	idempotency struct {
+		ttl time.Duration
+	}
 }
 
 // Define an application struct to hold the dependencies for our HTTP handlers, helpers, and middleware.
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
+	config  config
+	logger  *jsonlog.Logger
+	models  data.Models
+	storage storage.Storage
+	// The shutdown channel is closed when the server starts shutting down, which stops the background jobs. The wg WaitGroup tracks
+	// the running jobs, so that serve() can wait for them to finish.
+	shutdown chan struct{}
+	wg       sync.WaitGroup
 }
 
 func main() {
@@ -62,11 +98,33 @@ func main() {
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
 
+	flag.StringVar(&cfg.cursor.secret, "cursor-secret", os.Getenv("GREENLIGHT_CURSOR_SECRET"), "Secret key for signing pagination cursors")
+
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "Time before trashed movies are purged (0 to disable)")
+
+	flag.BoolVar(&cfg.conditional.requireIfMatch, "require-if-match", false, "Require an If-Match header on movie updates and deletions")
+
+	flag.StringVar(&cfg.search.language, "search-language", "simple", "Default PostgreSQL text search configuration for title searches")
+
+	flag.StringVar(&cfg.posters.dir, "posters-dir", "./uploads/posters", "Directory for uploaded movie posters")
+
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-ttl", 24*time.Hour, "Time that responses to requests with an Idempotency-Key are kept")
+
 	flag.Parse()
 
 	// Initialize a new jsonlog.Logger which writes any messages *at or above* the INFO severity level to the standard out stream.
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	// If no cursor secret was provided, generate a random one. This means that any cursors will stop working when the application restarts.
+	if cfg.cursor.secret == "" {
+		secret := make([]byte, 32)
+		_, err := rand.Read(secret)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		cfg.cursor.secret = string(secret)
+	}
+
 	// Call the openDB() helper function to create the connection pool, passing in the config struct.
 	db, error := openDB(cfg)
 	if error != nil {
@@ -79,13 +137,40 @@ func main() {
 	// Also log a message to say that the connection pool has been successfully established.
 	logger.PrintInfo("database connection pool established", nil)
 
+	models := data.NewModels(db)
+
+	// Load the names of the text search configurations which have a title index, which are the values accepted by the lang query string
+	// parameter, and check that the default configuration is one of them.
+	cfg.search.languages, error = models.Movies.TextSearchConfigs()
+	if error != nil {
+		logger.PrintFatal(error, nil)
+	}
+
+	if !validator.In(cfg.search.language, cfg.search.languages...) {
+		logger.PrintFatal(fmt.Errorf("unknown text search configuration %q", cfg.search.language), nil)
+	}
+
+	// Posters are stored on the local filesystem.
+	posters, error := storage.NewLocal(cfg.posters.dir)
+	if error != nil {
+		logger.PrintFatal(error, nil)
+	}
+
 	// Declare an instance of the application struct, containing the config struct and the logger.
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
+		config:   cfg,
+		logger:   logger,
+		models:   models,
+		storage:  posters,
+		shutdown: make(chan struct{}),
 	}
 
+	// Start the background purge of movies which have been in the trash for longer than the retention period.
+	app.purgeTrash()
+
+	// Start the background removal of expired idempotency keys.
+	app.purgeIdempotencyKeys()
+
 	// Call app.serve() to start the server.
 	error = app.serve()
 	if error != nil {
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// The mergeMovieHandler() merges a duplicate movie (the source) into the movie in the URL (the target). The client sends the versions of both
+// movies that it expects to merge, and lists the fields which should be taken from the source rather than the target.
+func (app *application) mergeMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	target, err := app.models.Movies.Get(id, app.movieVisibility(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Merging is a write to the target movie, so it honours the If-Match header in the same way as updateMovieHandler().
+	if !app.checkIfMatch(w, r, movieETag(target)) {
+		return
+	}
+
+	var input struct {
+		SourceID      int64    `json:"source_id"`
+		SourceVersion int32    `json:"source_version"`
+		Version       int32    `json:"version"`
+		FromSource    []string `json:"from_source"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.SourceID > 0, "source_id", "must be provided")
+	v.Check(input.SourceID != target.ID, "source_id", "must be a different movie")
+	v.Check(input.SourceVersion > 0, "source_version", "must be provided")
+	v.Check(input.Version > 0, "version", "must be provided")
+	v.Check(validator.Unique(input.FromSource), "from_source", "must not contain duplicate values")
+	for _, field := range input.FromSource {
+		v.Check(validator.In(field, data.MergeableMovieFields...), "from_source", fmt.Sprintf("invalid field %q", field))
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	source, err := app.models.Movies.Get(input.SourceID, app.movieVisibility(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("source_id", "movie does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// If either movie has changed since the client read it, the client needs to look at them again before deciding how to merge them.
+	if target.Version != input.Version || source.Version != input.SourceVersion {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	// Note the posters of both movies before the merge, so that whichever one isn't kept can be removed afterwards.
+	var oldPosters []string
+	for _, movie := range []*data.Movie{target, source} {
+		if movie.Poster != nil {
+			oldPosters = append(oldPosters, data.PosterKeyFromURL(movie.Poster.URL))
+		}
+	}
+
+	data.MergeMovie(target, source, input.FromSource)
+
+	// The merged movie must pass the same validation as any other update. In particular, the combined genres can't be more than the maximum.
+	genres, err := app.models.Genres.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidateMovie(v, target, genres); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Movies.Merge(target, source, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateIMDbID):
+			v.AddError("imdb_id", "a movie with this IMDb ID already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateTMDbID):
+			v.AddError("tmdb_id", "a movie with this TMDB ID already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Remove the files of the poster which the merged movie no longer uses, unless another movie is using the same image. The merge has
+	// already been saved, so any errors are logged rather than sent to the client.
+	for _, key := range oldPosters {
+		if target.Poster == nil || key != data.PosterKeyFromURL(target.Poster.URL) {
+			app.deletePosterFiles(r.Context(), key)
+		}
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(target))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": target}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The redirectMergedMovie() helper sends a 301 Moved Permanently response if the movie with the given ID has been merged into another movie,
+// pointing the client at the same URL for the other movie (including any subresource path and query string). It returns false if the movie
+// hasn't been merged.
+func (app *application) redirectMergedMovie(w http.ResponseWriter, r *http.Request, id int64) bool {
+	target, err := app.models.Movies.GetMergedInto(id)
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return true
+		}
+		return false
+	}
+
+	// Swap the ID in the path for the target's, keeping the rest of the path as it is. The ID is taken from the route parameter, rather than
+	// formatting id, so that the prefix matches exactly how the client wrote it.
+	prefix := "/v1/movies/" + httprouter.ParamsFromContext(r.Context()).ByName("id")
+	location := fmt.Sprintf("/v1/movies/%d", target) + strings.TrimPrefix(r.URL.Path, prefix)
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", location)
+
+	err = app.writeJSON(w, http.StatusMovedPermanently, envelope{"message": fmt.Sprintf("this movie has been merged into movie %d", target)}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+	return true
+}
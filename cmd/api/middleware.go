@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/julienschmidt/httprouter"
 	"golang.org/x/time/rate"
 	"greenlight.alexedwards.net/internal/data"
 	"greenlight.alexedwards.net/internal/validator"
@@ -195,6 +196,16 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 
 // Note that the first parameter for the middleware function is the permission code that we require the user to have.
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	return app.checkPermission(code, false, next)
+}
+
+// The requirePermissionOrOwner() middleware is like requirePermission(), but also lets a user with the ":own" variant of the permission code
+// make the request if they own the resource (see ownsResource()). It is only used for the routes which are listed as allowing this in routes.go.
+func (app *application) requirePermissionOrOwner(code string, next http.HandlerFunc) http.HandlerFunc {
+	return app.checkPermission(code, true, next)
+}
+
+func (app *application) checkPermission(code string, allowOwner bool, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		// Retrieve the user from the request context.
 		user := app.contextGetUser(r)
@@ -206,16 +217,75 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 			return
 		}
 
-		// Check if the slice includes the required permission. If it doesn't, then return a 403 Forbidden response.
+		// Check if the slice includes the required permission. If it doesn't, then check whether the user can make this request with the
+		// ":own" variant of the permission instead (if the route allows it), and if not return a 403 Forbidden response.
 		if !permissions.Include(code) {
-			app.notPermittedResponse(w, r)
-			return
+			owner := false
+			if allowOwner && permissions.Include(code+":own") {
+				owner, err = app.ownsResource(r, code)
+				if err != nil {
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+			}
+
+			if !owner {
+				app.notPermittedResponse(w, r)
+				return
+			}
 		}
 
-		// Otherwise they have the required permission so we call the next handler in the chain.
-		next.ServeHTTP(w, r)
+		// Otherwise they have the required permission so we call the next handler in the chain. The permissions are added to the request
+		// context, so that handlers which behave differently depending on the user's permissions don't need to read them again.
+		next.ServeHTTP(w, app.contextSetPermissions(r, permissions))
 	}
 
 	// Wrap this with the requireActivatedUser() middleware before returning it.
 	return app.requireActivatedUser(fn)
 }
+
+// The loadPermissions() middleware adds the current user's permissions to the request context, in the same way as requirePermission(), for
+// routes which any activated user can use but which depend on the user's permissions. For example, a watchlist only shows the movies which
+// the user can see.
+func (app *application) loadPermissions(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		permissions, err := app.models.Permissions.GetAllForUser(app.contextGetUser(r).ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, app.contextSetPermissions(r, permissions))
+	}
+
+	return app.requireActivatedUser(fn)
+}
+
+// The ownsResource() helper reports whether the current user owns the resource in the URL of a request. Only movies have owners, so this is
+// always false for other permission codes. A request without a movie ID creates a new movie, which will belong to the user.
+func (app *application) ownsResource(r *http.Request, code string) (bool, error) {
+	switch code {
+	case "movies:write":
+		if httprouter.ParamsFromContext(r.Context()).ByName("id") == "" {
+			return true, nil
+		}
+
+		id, err := app.readIDParam(r)
+		if err != nil {
+			return false, nil
+		}
+
+		// Movies which don't exist (or which pre-date ownership tracking) don't belong to anyone.
+		owner, err := app.models.Movies.GetOwner(id)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return false, nil
+			}
+			return false, err
+		}
+
+		return owner == app.contextGetUser(r).ID, nil
+	}
+
+	return false, nil
+}
@@ -1,14 +1,34 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"strings"
+	"time"
 
 	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/jsonpatch"
 	"greenlight.alexedwards.net/internal/validator"
 )
 
+// The supported sort values for the movie listing and export endpoints.
+var movieSortSafelist = []string{
+	"id", "title", "year", "runtime", "average_rating", "ratings_count",
+	"-id", "-title", "-year", "-runtime", "-average_rating", "-ratings_count",
+}
+
+// The movie listing can also be sorted by relevance to the title search term.
+var movieListSortSafelist = append([]string{"relevance"}, movieSortSafelist...)
+
+// The fields which can be requested in a sparse fieldset with the fields query string parameter.
+var movieFieldSafelist = []string{"id", "title", "year", "runtime", "genres", "version", "imdb_id", "tmdb_id", "created_by", "updated_by", "status",
+	"publish_at", "average_rating", "ratings_count", "poster", "score"}
+
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
 	// Declare an anonymous struct to hold the information that we expect to be in the HTTP request body.
 	// This struct will be our *target decode destination*.
@@ -17,6 +37,8 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		Year    int32        `json:"year"`
 		Runtime data.Runtime `json:"runtime"`
 		Genres  []string     `json:"genres"`
+		IMDbID  string       `json:"imdb_id"`
+		TMDbID  int64        `json:"tmdb_id"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -31,21 +53,57 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		Year:    input.Year,
 		Runtime: input.Runtime,
 		Genres:  input.Genres,
+		IMDbID:  input.IMDbID,
+		TMDbID:  input.TMDbID,
+	}
+
+	// Fetch the list of known genres, which the movie's genres are checked against.
+	genres, err := app.models.Genres.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	// Initialize a new Validator instance.
 	v := validator.New()
 
+	// The force parameter skips the duplicate check below.
+	force := app.readBool(r.URL.Query(), "force", false, v)
+
 	// Call the ValidateMovie() function and return a response containing the errors if any of the checks fail.
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
+	// Unless the client has confirmed that the movie is new, check whether it is already in the database under the same title and year. If it
+	// is, we send a 409 Conflict response listing the possible duplicates, so the client can either use one of them or try again with force=true.
+	if !force {
+		duplicates, err := app.models.Movies.FindDuplicates(movie.Title, movie.Year, app.movieVisibility(r))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if len(duplicates) > 0 {
+			app.duplicateMovieResponse(w, r, duplicates)
+			return
+		}
+	}
+
 	// This will create a record in the database and update the movie struct with the system-generated information.
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.Insert(movie, app.contextGetUser(r).ID)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateIMDbID):
+			v.AddError("imdb_id", "a movie with this IMDb ID already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateTMDbID):
+			v.AddError("tmdb_id", "a movie with this TMDB ID already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -53,8 +111,9 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	// We make an empty http.Header map and then use the Set() method to add a new Location header.
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", movieETag(movie))
 
-	// Write a JSON response with a 201 Created status code, the movie data in the response body, and the Location header.
+	// Write a JSON response with a 201 Created status code, the movie data in the response body, and the Location and ETag headers.
 	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -68,19 +127,76 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// use the errors.Is() function to check if it returns a data.ErrRecordNotFound error, in which case we send a 404 Not Found response to the client.
-	movie, err := app.models.Movies.Get(id)
+	// Read the optional sparse fieldset, which limits the response to the named fields, and the optional list of related resources to
+	// include in the response.
+	v := validator.New()
+
+	fields := app.readCSV(r.URL.Query(), "fields", nil)
+	include := app.readCSV(r.URL.Query(), "include", nil)
+
+	data.ValidateFields(v, fields, movieFieldSafelist)
+	for _, value := range include {
+		v.Check(validator.In(value, "credits"), "include", fmt.Sprintf("invalid include value %q", value))
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// use the errors.Is() function to check if it returns a data.ErrRecordNotFound error, in which case we send a 404 Not Found response to the client
+	// (unless the movie has been merged into another one, in which case the client is redirected to it).
+	movie, err := app.models.Movies.Get(id, app.movieVisibility(r), fields...)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
+			if !app.redirectMergedMovie(w, r, id) {
+				app.notFoundResponse(w, r)
+			}
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	// Include the ETag and Last-Modified headers, so that the client can make conditional requests. If the client already has the
+	// current version of the movie, we send a 304 Not Modified response instead of the movie data. A sparse fieldset is only part of the movie,
+	// so it gets a weak entity tag, which can't be used with If-Match.
+	etag := movieETag(movie)
+	if len(fields) > 0 {
+		etag = "W/" + etag
+	}
+	lastModified := movie.UpdatedAt
+
+	// The credits are stored separately from the movie, and changing them doesn't change the version of the movie, so when they are included
+	// the entity tag is computed from the credits as well, and there is no Last-Modified time. The credits are always included in a sparse
+	// fieldset.
+	if validator.In("credits", include...) {
+		movie.Credits, err = app.models.Credits.GetAllForMovie(movie.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		etag = movieCreditsETag(etag, movie.Credits)
+		lastModified = time.Time{}
+
+		if len(fields) > 0 {
+			fields = append(fields, "credits")
+		}
+	}
+
+	if app.checkNotModified(w, r, etag, lastModified) {
+		return
+	}
+
+	body, err := app.pickFields(movie, fields)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": body}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -95,7 +211,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Fetch the existing movie record from the database, sending a 404 Not Found response to the client if we couldn't find a matching record.
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(id, app.movieVisibility(r))
 
 	if err != nil {
 		switch {
@@ -107,64 +223,192 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Declare an input struct to hold the expected data from the client.
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
-	}
-
-	// Read the JSON request body data into the input struct.
-	err = app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
+	// If the client sent an If-Match header, check that it matches the movie that we just fetched. Because Update() only succeeds if the version
+	// hasn't changed since then, this guarantees that the client is updating the version of the movie they last saw.
+	if !app.checkIfMatch(w, r, movieETag(movie)) {
 		return
 	}
 
-	// Copy the values from the request body to the appropriate fields of the movie record.
-	// If the input.Title value is nil then we know that no corresponding "title" key/value pair was
-	// provided in the JSON request body. So we move on and leave the movie record unchanged.
-	if input.Title != nil {
-		movie.Title = *input.Title
-	}
-	if input.Year != nil {
-		movie.Year = *input.Year
-	}
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
+	v := validator.New()
+
+	// The format of the request body depends on its Content-Type. JSON Patch and JSON Merge Patch documents are applied to the
+	// current movie record, while any other body is treated as a plain JSON object containing the fields to change.
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "application/json-patch+json", "application/merge-patch+json":
+		err = app.readMoviePatch(w, r, movie, mediaType, v)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		// Problems applying the patch are reported in the same way as validation errors.
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+	default:
+		// Declare an input struct to hold the expected data from the client.
+		var input struct {
+			Title   *string       `json:"title"`
+			Year    *int32        `json:"year"`
+			Runtime *data.Runtime `json:"runtime"`
+			Genres  []string      `json:"genres"`
+			IMDbID  *string       `json:"imdb_id"`
+			TMDbID  *int64        `json:"tmdb_id"`
+		}
+
+		// Read the JSON request body data into the input struct.
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		// Copy the values from the request body to the appropriate fields of the movie record.
+		// If the input.Title value is nil then we know that no corresponding "title" key/value pair was
+		// provided in the JSON request body. So we move on and leave the movie record unchanged.
+		if input.Title != nil {
+			movie.Title = *input.Title
+		}
+		if input.Year != nil {
+			movie.Year = *input.Year
+		}
+		if input.Runtime != nil {
+			movie.Runtime = *input.Runtime
+		}
+		if input.Genres != nil {
+			movie.Genres = input.Genres
+		}
+		if input.IMDbID != nil {
+			movie.IMDbID = *input.IMDbID
+		}
+		if input.TMDbID != nil {
+			movie.TMDbID = *input.TMDbID
+		}
 	}
-	if input.Genres != nil {
-		movie.Genres = input.Genres
+
+	// Fetch the list of known genres, which the movie's genres are checked against.
+	genres, err := app.models.Genres.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	// Validate the updated movie record, sending the client a 422 Unprocessable Entity response if any checks fail.
-	v := validator.New()
-
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	// Pass the updated movie record to our new Update() method.
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(movie, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateIMDbID):
+			v.AddError("imdb_id", "a movie with this IMDb ID already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateTMDbID):
+			v.AddError("tmdb_id", "a movie with this TMDB ID already exists")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	// Write the updated movie record in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	// Write the updated movie record in a JSON response, along with its new ETag.
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// The readMoviePatch() helper reads a JSON Patch (RFC 6902) or JSON Merge Patch (RFC 7396) document from the request body and applies it to the
+// editable fields of the movie. It returns an error if the body can't be read, and records any problems applying the patch in the validator.
+func (app *application) readMoviePatch(w http.ResponseWriter, r *http.Request, movie *data.Movie, mediaType string, v *validator.Validator) error {
+	// Use http.MaxBytesReader() to limit the size of the request body to 1MB, the same as readJSON().
+	maxBytes := 1_048_576
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+		}
+		return err
+	}
+
+	switch {
+	case len(bytes.TrimSpace(patch)) == 0:
+		return errors.New("body must not be empty")
+	case !json.Valid(patch):
+		return errors.New("body contains badly-formed JSON")
+	}
+
+	// Build a JSON document containing the editable fields of the movie, which is what the patch paths refer to.
+	type movieDocument struct {
+		Title   string       `json:"title"`
+		Year    int32        `json:"year"`
+		Runtime data.Runtime `json:"runtime"`
+		Genres  []string     `json:"genres"`
+		IMDbID  string       `json:"imdb_id"`
+		TMDbID  int64        `json:"tmdb_id"`
+	}
+
+	js, err := json.Marshal(movieDocument{
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: movie.Runtime,
+		Genres:  movie.Genres,
+		IMDbID:  movie.IMDbID,
+		TMDbID:  movie.TMDbID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if mediaType == "application/merge-patch+json" {
+		js, err = jsonpatch.MergePatch(js, patch)
+	} else {
+		js, err = jsonpatch.Apply(js, patch)
+	}
+	if err != nil {
+		v.AddError("patch", err.Error())
+		return nil
+	}
+
+	// Decode the patched document back into a fresh struct. Any fields which the patch removed are left with their zero values,
+	// so they will fail validation, and any fields which it added that don't belong to the movie are rejected.
+	var document movieDocument
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&document)
+	if err != nil {
+		v.AddError("patch", strings.Replace(app.triageJSONError(err, maxBytes).Error(), "body contains", "patched movie contains", 1))
+		return nil
+	}
+
+	movie.Title = document.Title
+	movie.Year = document.Year
+	movie.Runtime = document.Runtime
+	movie.Genres = document.Genres
+	movie.IMDbID = document.IMDbID
+	movie.TMDbID = document.TMDbID
+
+	return nil
+}
+
 func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract the movie ID from the URL.
 	id, err := app.readIDParam(r)
@@ -173,12 +417,36 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Delete the movie from the database, sending a 404 Not Found response to the client if there isn't a matching record.
-	err = app.models.Movies.Delete(id)
+	// If the request is conditional (or the server requires it to be), fetch the movie and check its ETag before deleting it. The version
+	// which was checked is passed to Delete(), so that the movie isn't deleted if it is changed in the meantime.
+	var version int32
+	if r.Header.Get("If-Match") != "" || app.config.conditional.requireIfMatch {
+		movie, err := app.models.Movies.Get(id, app.movieVisibility(r))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if !app.checkIfMatch(w, r, movieETag(movie)) {
+			return
+		}
+		version = movie.Version
+	}
+
+	// Move the movie to the trash, sending a 404 Not Found response to the client if there isn't a matching record, or a 412 Precondition
+	// Failed response if the movie has changed since its ETag was checked.
+	err = app.models.Movies.Delete(id, version)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -194,8 +462,9 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieFilters
+		Fields []string
+		Facets []string
 		data.Filters
 	}
 
@@ -205,9 +474,8 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// Call r.URL.Query() to get the url.Values map containing the query string data.
 	qs := r.URL.Query()
 
-	// Use our helpers to extract the title and genres query string values, falling back to defaults of an empty string and an empty slice
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	// Read the title, genre, year, runtime and creation date filters.
+	input.MovieFilters = app.readMovieFilters(r, v)
 
 	// Get the page and page_size query string values as integers. Notice that we set the default page value to 1 and default page_size to 20.
 	input.Page = app.readInt(qs, "page", 1, v)
@@ -217,24 +485,97 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	input.Sort = app.readString(qs, "sort", "id")
 
 	// Add the supported sort values for this endpoint to the sort safelist.
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafelist = movieListSortSafelist
+
+	// Read the optional keyset cursor, which is signed with the key from our config. The total record count can be skipped by passing include_total=false.
+	input.Cursor = app.readString(qs, "cursor", "")
+	input.CursorSecret = []byte(app.config.cursor.secret)
+	input.IncludeTotal = app.readBool(qs, "include_total", true, v)
 
-	// Execute the validation checks on the Filters struct and send a response containing the errors if necessary.
-	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+	// Read the optional sparse fieldset, and the optional list of facets to calculate for the matching movies.
+	input.Fields = app.readCSV(qs, "fields", nil)
+	input.Facets = app.readCSV(qs, "facets", nil)
+
+	// Execute the validation checks on the filters and the fieldset, and send a response containing the errors if necessary.
+	data.ValidateMovieFilters(v, input.MovieFilters)
+	data.ValidateFilters(v, input.Filters)
+	data.ValidateFields(v, input.Fields, movieFieldSafelist)
+	data.ValidateFacets(v, input.Facets)
+
+	// Sorting by relevance only makes sense when there is something to be relevant to.
+	v.Check(input.Sort != "relevance" || input.Title != "", "sort", "relevance can only be used with a title search term")
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	// Call the GetAll() method to retrieve the movies, passing in the various filter parameters.
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, aggregations, err := app.models.Movies.GetAll(input.MovieFilters, input.Filters, input.Facets, input.Fields...)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Send a JSON response containing the movie data.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	// Send a 304 Not Modified response if the client's copy of the results is still current. The Last-Modified time is that of the most
+	// recently updated movie on the page. Movies can leave the results without anything being updated (when they are trashed, merged or
+	// purged, for example), which the entity tag does catch, so clients should prefer If-None-Match, which takes precedence.
+	if app.checkNotModified(w, r, movieListETag(movies, metadata, aggregations, input.Fields), movieListLastModified(movies)) {
+		return
+	}
+
+	// Narrow each movie down to the requested fields.
+	body := make([]interface{}, len(movies))
+	for i, movie := range movies {
+		body[i], err = app.pickFields(movie, input.Fields)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Send a JSON response containing the movie data. The aggregations are only included if some facets were requested.
+	env := envelope{"movies": body, "metadata": metadata}
+	if len(input.Facets) > 0 {
+		env["aggregations"] = aggregations
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// The readMovieFilters() helper reads the filters for listing and exporting movies from the query string. Any values which can't be parsed
+// are recorded in the provided Validator instance; the filters still need to be checked with data.ValidateMovieFilters(). The movies are always
+// limited to the ones which the current user can see.
+func (app *application) readMovieFilters(r *http.Request, v *validator.Validator) data.MovieFilters {
+	qs := r.URL.Query()
+
+	filters := data.MovieFilters{
+		Title:            app.readString(qs, "title", ""),
+		SearchMode:       app.readString(qs, "search_mode", "exact"),
+		Language:         app.readString(qs, "lang", app.config.search.language),
+		LanguageSafelist: app.config.search.languages,
+		Genres:           app.readCSV(qs, "genres", []string{}),
+		GenresAny:        app.readCSV(qs, "genres_any", []string{}),
+		ExcludeGenres:    app.readCSV(qs, "exclude_genres", []string{}),
+		Person:           int64(app.readInt(qs, "person", 0, v)),
+		Status:           app.readString(qs, "status", ""),
+		Visibility:       app.movieVisibility(r),
+		YearMin:          app.readInt(qs, "year_min", 0, v),
+		YearMax:          app.readInt(qs, "year_max", 0, v),
+		RuntimeMin:       app.readInt(qs, "runtime_min", 0, v),
+		RuntimeMax:       app.readInt(qs, "runtime_max", 0, v),
+		CreatedAfter:     app.readTime(qs, "created_after", v),
+		CreatedBefore:    app.readTime(qs, "created_before", v),
+	}
+
+	// The only supported value of the owner filter is "me", for the movies created by the current user.
+	if owner := app.readString(qs, "owner", ""); owner != "" {
+		v.Check(owner == "me", "owner", "must be me")
+		filters.CreatedBy = app.contextGetUser(r).ID
+	}
+
+	return filters
+}
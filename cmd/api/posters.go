@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	// Register the GIF decoder with the image package. The JPEG and PNG decoders are registered by the imports above.
+	_ "image/gif"
+
+	"github.com/julienschmidt/httprouter"
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/storage"
+	"greenlight.alexedwards.net/internal/thumbnail"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+const (
+	// The maximum size of a poster upload request body (10MB). readJSON()'s 1MB limit doesn't apply, as the body isn't JSON.
+	maxPosterBytes = 10_485_760
+
+	// The minimum and maximum dimensions of a poster, in pixels. The maximum stops small files which decode to enormous images from
+	// using up all of the server's memory.
+	minPosterSize = 100
+	maxPosterSize = 6000
+)
+
+// The posterFormats map holds the content types which are accepted for posters, and the names of their image formats.
+var posterFormats = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+// The posterFileRX pattern matches the file names of posters and their thumbnails, as generated by data.PosterKey() and data.ThumbnailKey().
+var posterFileRX = regexp.MustCompile(`^(original|w[0-9]+)\.(jpeg|png|gif)$`)
+
+// The posterHashRX pattern matches the hex-encoded SHA-256 hashes which posters are stored under.
+var posterHashRX = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+func (app *application) uploadPosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id, app.movieVisibility(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// As with updateMovieHandler(), uploading a poster creates a new version of the movie, so the If-Match header is checked.
+	if !app.checkIfMatch(w, r, movieETag(movie)) {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPosterBytes)
+
+	file, err := app.readPosterFile(r)
+	if err != nil {
+		if errors.Is(err, http.ErrNotMultipart) {
+			app.unsupportedMediaTypeResponse(w, r, "multipart/form-data")
+			return
+		}
+
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			err = fmt.Errorf("body must not be larger than %d bytes", maxPosterBytes)
+		}
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	img, format := decodePoster(v, file)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Store the original file and its thumbnails under the hash of the file. If the same image has been uploaded before, the files are
+	// simply overwritten with identical copies.
+	hash := sha256.Sum256(file)
+	key := data.PosterKey(hex.EncodeToString(hash[:]), format)
+
+	err = app.storage.Put(r.Context(), key, bytes.NewReader(file))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Convert the image to RGBA once for all of the thumbnails, rather than for each of them, as a large poster takes a lot of memory.
+	rgba := thumbnail.RGBA(img)
+
+	for _, width := range data.PosterWidths {
+		var buf bytes.Buffer
+
+		thumb := thumbnail.Resize(rgba, width)
+		if format == "jpeg" {
+			err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buf, thumb)
+		}
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.storage.Put(r.Context(), data.ThumbnailKey(key, width), &buf)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	oldPoster := movie.Poster
+
+	err = app.models.Movies.SetPoster(movie, key, app.contextGetUser(r).ID)
+	if err != nil {
+		// The new files aren't used, unless the same image is already the poster of another movie.
+		app.deletePosterFiles(r.Context(), key)
+
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Remove the files of the poster which has been replaced, unless another movie is using the same image. The new poster has already been
+	// saved, so any errors are logged rather than sent to the client.
+	if oldPoster != nil {
+		app.deletePosterFiles(r.Context(), data.PosterKeyFromURL(oldPoster.URL))
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readPosterFile() helper returns the contents of the "poster" part of a multipart/form-data request body. Any other parts are skipped.
+func (app *application) readPosterFile(r *http.Request) ([]byte, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errors.New(`body must contain a "poster" file`)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() == "poster" {
+			return io.ReadAll(part)
+		}
+	}
+}
+
+// The decodePoster() function checks that a poster file is a JPEG, PNG or GIF image of an acceptable size, and decodes it. The content type
+// is detected from the file itself, and must agree with the format that the image decodes as, so the file name and the Content-Type header
+// sent by the client are never trusted. Any problems are recorded in the validator.
+func decodePoster(v *validator.Validator, file []byte) (image.Image, string) {
+	format, ok := posterFormats[http.DetectContentType(file)]
+	if v.Check(ok, "poster", "must be a JPEG, PNG or GIF image"); !v.Valid() {
+		return nil, ""
+	}
+
+	// Check the dimensions before decoding the whole image.
+	config, configFormat, err := image.DecodeConfig(bytes.NewReader(file))
+	if v.Check(err == nil && configFormat == format, "poster", "must be a valid image"); !v.Valid() {
+		return nil, ""
+	}
+
+	v.Check(config.Width >= minPosterSize && config.Height >= minPosterSize, "poster", fmt.Sprintf("must be at least %d pixels wide and high", minPosterSize))
+	v.Check(config.Width <= maxPosterSize && config.Height <= maxPosterSize, "poster", fmt.Sprintf("must not be more than %d pixels wide or high", maxPosterSize))
+	if !v.Valid() {
+		return nil, ""
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(file))
+	if v.Check(err == nil, "poster", "must be a valid image"); !v.Valid() {
+		return nil, ""
+	}
+
+	return img, format
+}
+
+// The deletePosterFiles() helper removes a poster and its thumbnails from storage, if no movie uses it any more. It's used once the change
+// which stopped using the poster has been saved, so any errors are logged rather than returned.
+func (app *application) deletePosterFiles(ctx context.Context, key string) {
+	inUse, err := app.models.Movies.PosterInUse(key)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"poster": key})
+		return
+	}
+	if inUse {
+		return
+	}
+
+	keys := []string{key}
+	for _, width := range data.PosterWidths {
+		keys = append(keys, data.ThumbnailKey(key, width))
+	}
+
+	for _, key := range keys {
+		err := app.storage.Delete(ctx, key)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			app.logger.PrintError(err, map[string]string{"poster": key})
+		}
+	}
+}
+
+// The showPosterHandler() serves a poster or thumbnail file. The files are stored under the hash of the original poster and are never changed,
+// so they can be cached indefinitely. This endpoint doesn't need any permissions, so that the images can be used in <img> tags, which can't
+// send an Authorization header.
+func (app *application) showPosterHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	hash, file := params.ByName("hash"), params.ByName("file")
+	if !posterHashRX.MatchString(hash) || !posterFileRX.MatchString(file) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	key := hash + "/" + file
+
+	body, err := app.storage.Get(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+
+	if app.checkNotModified(w, r, fmt.Sprintf("%q", key), time.Time{}) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/"+posterFileRX.FindStringSubmatch(file)[2])
+
+	_, err = io.Copy(w, body)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -50,8 +50,19 @@ func (app *application) serve() error {
 
 		// Call Shutdown() on our server, passing in the context we just made. Shutdown() will return nil if the graceful shutdown was successful, or an
 		// error (which may happen because of a problem closing the listeners, or
-		// because the shutdown didn't complete before the 5-second context deadline is hit). We relay this return value to the shutdownError channel.
-		shutdownError <- srv.Shutdown(ctx)
+		// because the shutdown didn't complete before the 5-second context deadline is hit).
+		err := srv.Shutdown(ctx)
+
+		// Stop the background jobs, and wait for any which are running to finish, even if the server didn't shut down cleanly.
+		app.logger.PrintInfo("completing background tasks", map[string]string{
+			"addr": srv.Addr,
+		})
+
+		close(app.shutdown)
+		app.wg.Wait()
+
+		// Relay the return value from Shutdown() to the shutdownError channel. serve() only receives one value, so this is the only send.
+		shutdownError <- err
 	}()
 
 	// Start the HTTP server.
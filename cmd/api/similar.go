@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+func (app *application) listSimilarMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	// Similar movies are a short list of recommendations, so only the page size can be set and there is no pagination.
+	pageSize := app.readInt(r.URL.Query(), "page_size", 10, v)
+
+	v.Check(pageSize > 0, "page_size", "must be greater than zero")
+	v.Check(pageSize <= 100, "page_size", "must be a maximum of 100")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Check that the movie exists, isn't in the trash, and is visible to the user.
+	if !app.checkMovieVisible(w, r, id) {
+		return
+	}
+
+	movies, err := app.models.Movies.GetSimilar(id, pageSize, app.movieVisibility(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
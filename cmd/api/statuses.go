@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// The updateMovieStatusHandler() moves a movie to a new status in the publishing workflow. Publishing is the final step, so it also needs the
+// movies:publish permission, and can be scheduled for a future time by sending publish_at.
+func (app *application) updateMovieStatusHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id, app.movieVisibility(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Changing the status creates a new version of the movie, so the If-Match header is checked in the same way as updateMovieHandler().
+	if !app.checkIfMatch(w, r, movieETag(movie)) {
+		return
+	}
+
+	var input struct {
+		Status    string     `json:"status"`
+		PublishAt *time.Time `json:"publish_at"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateStatusChange(v, movie, input.Status, input.PublishAt); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if input.Status == data.MovieStatusPublished && !app.contextGetPermissions(r).Include("movies:publish") {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	movie.Status = input.Status
+	movie.PublishAt = input.PublishAt
+
+	err = app.models.Movies.SetStatus(movie, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -57,8 +57,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Add the "movies:read" permission for the new user.
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
+	// Add the "movies:read", "people:read" and "reviews:write" permissions for the new user.
+	err = app.models.Permissions.AddForUser(user.ID, "movies:read", "people:read", "reviews:write")
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
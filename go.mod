@@ -3,8 +3,8 @@ module greenlight.alexedwards.net
 go 1.20
 
 require (
-	github.com/julienschmidt/httprouter v1.3.0 // indirect
-	github.com/lib/pq v1.10.9 // indirect
-	golang.org/x/crypto v0.11.0 // indirect
-	golang.org/x/time v0.3.0 // indirect
+	github.com/julienschmidt/httprouter v1.3.0
+	github.com/lib/pq v1.10.9
+	golang.org/x/crypto v0.11.0
+	golang.org/x/time v0.3.0
 )
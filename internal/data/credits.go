@@ -0,0 +1,148 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// Define a custom ErrDuplicateCredit error, which is returned when a person is credited with the same role on a movie twice.
+var ErrDuplicateCredit = errors.New("duplicate credit")
+
+// The CreditRoles are the roles which a person can be credited with.
+var CreditRoles = []string{"director", "writer", "actor"}
+
+// The Credit struct links a person to a movie. The billing order controls the order in which people with the same role are listed.
+type Credit struct {
+	ID           int64  `json:"id"`
+	MovieID      int64  `json:"-"`
+	PersonID     int64  `json:"person_id"`
+	Name         string `json:"name"` // the person's name, which is included so that clients can show the credits without fetching every person
+	Role         string `json:"role"`
+	Character    string `json:"character,omitempty"` // only used for actors
+	BillingOrder int32  `json:"billing_order"`
+}
+
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.PersonID > 0, "person_id", "must be provided")
+
+	v.Check(credit.Role != "", "role", "must be provided")
+	v.Check(validator.In(credit.Role, CreditRoles...), "role", "must be one of director, writer or actor")
+
+	v.Check(credit.Character == "" || credit.Role == "actor", "character", "must only be provided for actors")
+	v.Check(len(credit.Character) <= 500, "character", "must not be more than 500 bytes long")
+
+	v.Check(credit.BillingOrder >= 0, "billing_order", "must not be negative")
+}
+
+// Define a CreditModel struct type which wraps a sql.DB connection pool.
+type CreditModel struct {
+	DB *sql.DB
+}
+
+// The Insert() method adds a credit to a movie. ErrRecordNotFound is returned if the person doesn't exist.
+func (m CreditModel) Insert(credit *Credit) error {
+	query := `
+			INSERT INTO movie_credits (movie_id, person_id, role, character, billing_order)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, (SELECT name FROM people WHERE id = $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{credit.MovieID, credit.PersonID, credit.Role, credit.Character, credit.BillingOrder}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&credit.ID, &credit.Name)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "movie_credits_movie_id_person_id_role_key"`:
+			return ErrDuplicateCredit
+		case err.Error() == `pq: insert or update on table "movie_credits" violates foreign key constraint "movie_credits_person_id_fkey"`:
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The Delete() method removes a credit from a movie.
+func (m CreditModel) Delete(movieID, id int64) error {
+	if movieID < 1 || id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM movie_credits WHERE movie_id = $1 AND id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movieID, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// The GetAllForMovie() method returns every credit for a movie: directors first, then writers, then actors, each in billing order.
+// A movie has a limited number of credits, so they aren't paginated.
+func (m CreditModel) GetAllForMovie(movieID int64) ([]*Credit, error) {
+	query := `
+			SELECT movie_credits.id, movie_credits.movie_id, movie_credits.person_id, people.name,
+				movie_credits.role, movie_credits.character, movie_credits.billing_order
+			FROM movie_credits
+			INNER JOIN people ON people.id = movie_credits.person_id
+			WHERE movie_credits.movie_id = $1
+			ORDER BY array_position($2::text[], movie_credits.role), movie_credits.billing_order, movie_credits.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, pq.Array(CreditRoles))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.Name,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
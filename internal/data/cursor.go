@@ -0,0 +1,69 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Define an error that decodeCursor() can return if a cursor is malformed or its signature doesn't match.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// The cursor struct records the position of the last row on a page: the sort parameter in effect, the value of the sort column for that row,
+// and its ID (which acts as a tie-breaker for rows with the same sort value). The sort value is stored as a string and cast by PostgreSQL
+// when it is compared against the column.
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"i"`
+}
+
+// The encode() method returns the cursor as an opaque string in the format "<payload>.<signature>", where the payload is the base64-encoded
+// JSON representation of the cursor and the signature is a base64-encoded HMAC-SHA256 of the payload.
+func (c cursor) encode(secret []byte) (string, error) {
+	js, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(js)
+
+	return payload + "." + signCursor(payload, secret), nil
+}
+
+// The decodeCursor() function verifies the signature on an opaque cursor string and decodes it, returning ErrInvalidCursor if the
+// string has been tampered with or isn't in the expected format.
+func decodeCursor(s string, secret []byte) (cursor, error) {
+	payload, signature, found := strings.Cut(s, ".")
+	if !found {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	// Use hmac.Equal() to compare the signatures in constant time.
+	if !hmac.Equal([]byte(signature), []byte(signCursor(payload, secret))) {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	js, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	var c cursor
+
+	err = json.Unmarshal(js, &c)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+func signCursor(payload string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
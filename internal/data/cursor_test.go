@@ -0,0 +1,75 @@
+package data
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	secret := []byte("secret")
+
+	tests := []cursor{
+		{Sort: "id", Value: "42", ID: 42},
+		{Sort: "-title", Value: "The Breakfast Club", ID: 7},
+		{Sort: "year", Value: "", ID: 1},
+		{Sort: "title", Value: "Amélie . with dots / and \"quotes\"", ID: 9},
+	}
+
+	for _, want := range tests {
+		s, err := want.encode(secret)
+		if err != nil {
+			t.Fatalf("encode %+v: %v", want, err)
+		}
+
+		got, err := decodeCursor(s, secret)
+		if err != nil {
+			t.Fatalf("decode %+v: %v", want, err)
+		}
+		if got != want {
+			t.Errorf("got %+v; want %+v", got, want)
+		}
+	}
+}
+
+func TestDecodeCursorRejectsTampering(t *testing.T) {
+	secret := []byte("secret")
+
+	valid, err := cursor{Sort: "id", Value: "42", ID: 42}.encode(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, signature, _ := strings.Cut(valid, ".")
+
+	// A payload for a different position, signed with the wrong secret.
+	forged, err := cursor{Sort: "id", Value: "1", ID: 1}.encode([]byte("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+
+	tests := []struct {
+		name   string
+		cursor string
+		secret []byte
+	}{
+		{"empty", "", secret},
+		{"no signature", payload, secret},
+		{"empty signature", payload + ".", secret},
+		{"wrong secret", valid, []byte("other")},
+		{"modified payload", "x" + valid, secret},
+		{"modified signature", payload + "." + strings.ToUpper(signature), secret},
+		{"payload from another cursor", forgedPayload + "." + signature, secret},
+		{"signed but not base64", "!!!." + signCursor("!!!", secret), secret},
+		{"signed but not JSON", "bm90IGpzb24." + signCursor("bm90IGpzb24", secret), secret},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeCursor(tt.cursor, tt.secret)
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("got %v; want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// The Aggregations type holds the facet counts for the movies matching a set of filters, keyed by the name of the facet.
+type Aggregations map[string][]FacetCount
+
+// The FacetCount struct holds the number of matching movies for a single value of a facet.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// The facetQueries map holds the SQL for each of the facets which can be requested. Each query aggregates over the matches common table
+// expression in GetAll(), so it counts every movie matching the filters rather than just the current page, and returns a JSON array of
+// value/count objects. The keys of this map also act as the safelist for the facets parameter.
+var facetQueries = map[string]string{
+	"genres": `
+		SELECT json_agg(json_build_object('value', genre, 'count', n) ORDER BY n DESC, genre)
+		FROM (SELECT unnest(genres) AS genre, count(*) AS n FROM matches GROUP BY genre) AS g`,
+	"decades": `
+		SELECT json_agg(json_build_object('value', decade::text || 's', 'count', n) ORDER BY decade)
+		FROM (SELECT (year / 10) * 10 AS decade, count(*) AS n FROM matches GROUP BY decade) AS d`,
+	"runtimes": `
+		SELECT json_agg(json_build_object('value', bucket, 'count', n) ORDER BY shortest)
+		FROM (
+			SELECT CASE
+				WHEN runtime < 90 THEN 'under 90 mins'
+				WHEN runtime < 120 THEN '90-119 mins'
+				WHEN runtime < 150 THEN '120-149 mins'
+				ELSE '150+ mins'
+			END AS bucket, min(runtime) AS shortest, count(*) AS n
+			FROM matches
+			GROUP BY bucket
+		) AS r`,
+}
+
+// The ValidateFacets() function checks that every facet requested by the client is one that we know how to calculate.
+func ValidateFacets(v *validator.Validator, facets []string) {
+	for _, facet := range facets {
+		if _, ok := facetQueries[facet]; !ok {
+			v.AddError("facets", fmt.Sprintf("invalid facet %q", facet))
+			return
+		}
+	}
+
+	v.Check(validator.Unique(facets), "facets", "must not contain duplicate values")
+}
+
+// The facetsColumn() function returns a SQL expression which builds a JSON object containing the requested facets, or NULL if there aren't any.
+// The subqueries aren't correlated with the outer query, so PostgreSQL only evaluates them once however many rows are returned.
+func facetsColumn(facets []string) string {
+	if len(facets) == 0 {
+		return "NULL"
+	}
+
+	pairs := make([]string, len(facets))
+	for i, facet := range facets {
+		query, ok := facetQueries[facet]
+		if !ok {
+			panic("unsafe facets parameter: " + facet)
+		}
+		pairs[i] = fmt.Sprintf("'%s', COALESCE((%s), '[]'::json)", facet, query)
+	}
+
+	return fmt.Sprintf("json_build_object(%s)", strings.Join(pairs, ", "))
+}
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"fmt"
 	"math"
 	"strings"
 
@@ -12,6 +13,9 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	Cursor       string // opaque keyset cursor returned as next_cursor by a previous request
+	CursorSecret []byte // key used to sign and verify cursors
+	IncludeTotal bool   // whether to calculate the total number of matching records
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -21,10 +25,41 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 
+	ValidateSort(v, f)
+
+	// If a cursor has been provided, check that it has a valid signature and was generated for the same sort order.
+	// Cursors replace page numbers, so the two can't be used together.
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor, f.CursorSecret)
+		if err != nil {
+			v.AddError("cursor", "invalid cursor")
+			return
+		}
+
+		v.Check(c.Sort == f.Sort, "cursor", "does not match the sort value")
+		v.Check(f.Page == 1, "page", "must not be used together with cursor")
+	}
+}
+
+// The ValidateSort() function only checks the sort parameter, for endpoints which don't paginate their results.
+func ValidateSort(v *validator.Validator, f Filters) {
 	// Check that the sort parameter matches a value in the safelist.
 	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
 }
 
+// The ValidateFields() function checks a sparse fieldset requested by the client. As with the sort parameter, every field must appear in the
+// safelist, which keeps the names that are interpolated into the SQL query under our control.
+func ValidateFields(v *validator.Validator, fields []string, safelist []string) {
+	for _, field := range fields {
+		if !validator.In(field, safelist...) {
+			v.AddError("fields", fmt.Sprintf("invalid field %q", field))
+			return
+		}
+	}
+
+	v.Check(validator.Unique(fields), "fields", "must not contain duplicate values")
+}
+
 // Check that the client-provided Sort field matches one of the entries in our safelist and if it does, extract the column name
 // from the Sort field by stripping the leading hyphen character (if one exists).
 func (f Filters) sortColumn() string {
@@ -54,13 +89,49 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// The total record count can't be calculated with a window function when paging with a cursor (it would only count the rows after the cursor),
+// so it is only included for page-based requests which ask for it.
+func (f Filters) includeTotal() bool {
+	return f.IncludeTotal && f.Cursor == ""
+}
+
+// The keyset() method returns a SQL condition which restricts the results to the rows after the position recorded in the cursor, along with the
+// placeholder arguments it uses. The placeholders are numbered from n. If no cursor was provided the condition always evaluates to true.
+func (f Filters) keyset(n int) (string, []interface{}) {
+	if f.Cursor == "" {
+		return "TRUE", nil
+	}
+
+	// The cursor will already have been checked by ValidateFilters(), so a failure here is a logic error in our codebase.
+	c, err := decodeCursor(f.Cursor, f.CursorSecret)
+	if err != nil {
+		panic("unsafe cursor parameter: " + f.Cursor)
+	}
+
+	// Rows with the same sort value are always ordered by ascending ID, whatever the sort direction.
+	operator := ">"
+	if f.sortDirection() == "DESC" {
+		operator = "<"
+	}
+
+	condition := fmt.Sprintf("(%[1]s %[2]s $%[3]d OR (%[1]s = $%[3]d AND id > $%[4]d))", f.sortColumn(), operator, n, n+1)
+
+	return condition, []interface{}{c.Value, c.ID}
+}
+
+// The nextCursor() method returns an encoded cursor pointing at the row with the given sort value and ID.
+func (f Filters) nextCursor(value string, id int64) (string, error) {
+	return cursor{Sort: f.Sort, Value: value, ID: id}.encode(f.CursorSecret)
+}
+
 // Define a new Metadata struct for holding the pagination metadata.
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
 }
 
 // The calculateMetadata() function calculates the appropriate pagination metadata values given the total number of records, current page, and page size values.
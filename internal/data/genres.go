@@ -0,0 +1,294 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// Define a custom ErrDuplicateGenre error, which is returned when a genre is created or renamed with a slug that is already taken.
+var ErrDuplicateGenre = errors.New("duplicate genre")
+
+// The slugRX pattern matches valid genre slugs: lowercase letters and digits, separated by single hyphens.
+var slugRX = regexp.MustCompile("^[a-z0-9]+(?:-[a-z0-9]+)*$")
+
+// The Genre struct holds one of the canonical genres. Movies refer to genres by their slug, so the genres field of a movie is still a list of
+// strings, and the name is only used for display.
+type Genre struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(genre.Slug != "", "slug", "must be provided")
+	v.Check(len(genre.Slug) <= 50, "slug", "must not be more than 50 bytes long")
+	v.Check(validator.Matches(genre.Slug, slugRX), "slug", "must only contain lowercase letters, digits and single hyphens")
+
+	v.Check(genre.Name != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 100, "name", "must not be more than 100 bytes long")
+}
+
+// The validateGenres() function checks that every genre of a movie is the slug of a known genre. For anything else, the error message
+// suggests the closest known genre, if there is one which is close enough to be a likely match.
+func validateGenres(v *validator.Validator, values []string, genres []*Genre) {
+	known := make(map[string]bool, len(genres))
+	for _, genre := range genres {
+		known[genre.Slug] = true
+	}
+
+	for _, value := range values {
+		if known[value] {
+			continue
+		}
+
+		if suggestion := suggestGenre(value, genres); suggestion != "" {
+			v.AddError("genres", fmt.Sprintf("unknown genre %q (did you mean %q?)", value, suggestion))
+		} else {
+			v.AddError("genres", fmt.Sprintf("unknown genre %q", value))
+		}
+		return
+	}
+}
+
+// The suggestGenre() function returns the slug of the known genre which is closest to value, comparing it with both the slug and the display
+// name of each genre, ignoring case. An exact match on the name (like "Sci-Fi" for sci-fi) is always suggested; otherwise the edit distance
+// must be no more than a third of the length of the value (and at least 2), or nothing is suggested.
+func suggestGenre(value string, genres []*Genre) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	best := ""
+	bestDistance := len(value)/3 + 1
+	if bestDistance < 3 {
+		bestDistance = 3
+	}
+
+	for _, genre := range genres {
+		for _, candidate := range []string{genre.Slug, strings.ToLower(genre.Name)} {
+			distance := levenshtein(value, candidate)
+			if distance < bestDistance {
+				best = genre.Slug
+				bestDistance = distance
+			}
+		}
+	}
+
+	return best
+}
+
+// The levenshtein() function returns the edit distance between two strings: the number of single character insertions, deletions or
+// substitutions needed to turn one into the other.
+func levenshtein(a, b string) int {
+	s, t := []rune(a), []rune(b)
+
+	previous := make([]int, len(t)+1)
+	current := make([]int, len(t)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(s); i++ {
+		current[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(t)]
+}
+
+// Define a GenreModel struct type which wraps a sql.DB connection pool.
+type GenreModel struct {
+	DB *sql.DB
+}
+
+// The GetAll() method returns every genre, ordered by name. The list of genres is short, so it isn't paginated.
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	query := `SELECT id, created_at, slug, name, version FROM genres ORDER BY name, slug`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(&genre.ID, &genre.CreatedAt, &genre.Slug, &genre.Name, &genre.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+// The Get() method returns the genre with a specific slug.
+func (m GenreModel) Get(slug string) (*Genre, error) {
+	query := `SELECT id, created_at, slug, name, version FROM genres WHERE slug = $1`
+
+	var genre Genre
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, slug).Scan(&genre.ID, &genre.CreatedAt, &genre.Slug, &genre.Name, &genre.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &genre, nil
+}
+
+func (m GenreModel) Insert(genre *Genre) error {
+	query := `
+			INSERT INTO genres (slug, name)
+			VALUES ($1, $2)
+			RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, genre.Slug, genre.Name).Scan(&genre.ID, &genre.CreatedAt, &genre.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "genres_slug_key"`:
+			return ErrDuplicateGenre
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The Update() method renames a genre, using the version number for optimistic locking. If the slug has changed, every movie with the old
+// slug is updated to use the new one in the same transaction. Those movies get a new version and revision, recorded against userID, just as
+// if they had been edited through MovieModel.Update().
+func (m GenreModel) Update(genre *Genre, oldSlug string, userID int64) error {
+	query := `
+			UPDATE genres
+			SET slug = $1, name = $2, version = version + 1
+			WHERE id = $3 AND version = $4
+			RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, genre.Slug, genre.Name, genre.ID, genre.Version).Scan(&genre.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "genres_slug_key"`:
+			return ErrDuplicateGenre
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if genre.Slug != oldSlug {
+		err = replaceMovieGenre(ctx, tx, oldSlug, genre.Slug, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// The Merge() method merges the source genre into the target genre: every movie with the source genre gets the target genre instead (unless
+// it already has it), and then the source genre is deleted. As with Update(), the changed movies get a new version and revision.
+func (m GenreModel) Merge(source, target *Genre, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = replaceMovieGenre(ctx, tx, source.Slug, target.Slug, userID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM genres WHERE id = $1 AND version = $2`, source.ID, source.Version)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return tx.Commit()
+}
+
+// The replaceMovieGenre() function replaces one genre slug with another in every movie (including those in the trash), without creating
+// duplicates, and records a revision for each changed movie.
+func replaceMovieGenre(ctx context.Context, tx *sql.Tx, from, to string, userID int64) error {
+	query := `
+			WITH updated AS (
+				UPDATE movies
+				SET genres = CASE WHEN $2 = ANY(genres) THEN array_remove(genres, $1) ELSE array_replace(genres, $1, $2) END,
+					updated_at = NOW(), updated_by = $3, version = version + 1
+				WHERE $1 = ANY(genres)
+				RETURNING id, title, year, runtime, genres, imdb_id, tmdb_id, version
+			)
+			INSERT INTO movie_revisions (movie_id, version, title, year, runtime, genres, imdb_id, tmdb_id, user_id)
+			SELECT id, version, title, year, runtime, genres, imdb_id, tmdb_id, $3
+			FROM updated`
+
+	_, err := tx.ExecContext(ctx, query, from, to, userID)
+	return err
+}
@@ -0,0 +1,60 @@
+package data
+
+import "testing"
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"drama", "drama", 0},
+		{"", "drama", 5},
+		{"drama", "", 5},
+		{"drama", "darma", 2},
+		{"comedy", "comdy", 1},
+		{"kitten", "sitting", 3},
+		{"thriller", "thriler", 1},
+		{"café", "cafe", 1},
+	}
+
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d; want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := levenshtein(tt.b, tt.a); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d; want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestSuggestGenre(t *testing.T) {
+	genres := []*Genre{
+		{Slug: "action", Name: "Action"},
+		{Slug: "comedy", Name: "Comedy"},
+		{Slug: "documentary", Name: "Documentary"},
+		{Slug: "sci-fi", Name: "Science Fiction"},
+	}
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"comdy", "comedy"},
+		{"  Comedy ", "comedy"},
+		{"acton", "action"},
+		{"science fiction", "sci-fi"},
+		{"Science Fction", "sci-fi"},
+		{"documentry", "documentary"},
+		{"scifi", "sci-fi"},
+		{"romance", ""},
+		{"x", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := suggestGenre(tt.value, genres); got != tt.want {
+			t.Errorf("suggestGenre(%q) = %q; want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrIdempotencyKeyReused is returned when an idempotency key is sent again with a different request.
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused")
+	// ErrIdempotencyKeyLocked is returned when the request which first used an idempotency key is still being processed.
+	ErrIdempotencyKeyLocked = errors.New("idempotency key locked")
+)
+
+const (
+	// While a request is being processed its key is leased for idempotencyLease, rather than for the full TTL. If the server stops before
+	// the response is saved, the key becomes available again once the lease expires. This must be longer than the server's write timeout.
+	idempotencyLease = time.Minute
+	// How long a repeated request waits for the first request with the same key to finish, and how often it checks.
+	idempotencyWait         = 5 * time.Second
+	idempotencyPollInterval = 100 * time.Millisecond
+)
+
+// The IdempotentResponse struct holds a response which has been stored so that it can be sent again when a request is repeated.
+type IdempotentResponse struct {
+	Status int
+	Header map[string][]string
+	Body   []byte
+}
+
+// The IdempotencyKey struct holds a claim on an idempotency key. If the key has been used before, Response holds the stored response;
+// otherwise the key is marked as being processed until the claim is saved or released.
+type IdempotencyKey struct {
+	Response *IdempotentResponse
+	db       *sql.DB
+	userID   int64
+	key      string
+	ttl      time.Duration
+}
+
+// Define an IdempotencyModel struct type which wraps a sql.DB connection pool.
+type IdempotencyModel struct {
+	DB *sql.DB
+}
+
+// The Claim() method claims an idempotency key for a user, recording the fingerprint of the request if the key is new (or its earlier use has
+// expired). If another request with the same key is still being processed, Claim() waits for it to finish so that its response can be
+// replayed, and returns ErrIdempotencyKeyLocked if that takes too long. ErrIdempotencyKeyReused is returned if the key was used for a
+// request with a different fingerprint.
+//
+// Each step is a single short statement, so no database connection is held while the request is processed or while waiting.
+func (m IdempotencyModel) Claim(userID int64, key string, fingerprint []byte, ttl time.Duration) (*IdempotencyKey, error) {
+	claim := &IdempotencyKey{db: m.DB, userID: userID, key: key, ttl: ttl}
+	deadline := time.Now().Add(idempotencyWait)
+
+	for {
+		claimed, err := m.insert(userID, key, fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		if claimed {
+			return claim, nil
+		}
+
+		response, storedFingerprint, err := m.get(userID, key)
+		if err != nil {
+			// The key may have expired and been deleted since the insert, in which case it can be claimed on the next attempt.
+			if !errors.Is(err, ErrRecordNotFound) {
+				return nil, err
+			}
+		} else {
+			if !bytes.Equal(storedFingerprint, fingerprint) {
+				return nil, ErrIdempotencyKeyReused
+			}
+			if response != nil {
+				claim.Response = response
+				return claim, nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return nil, ErrIdempotencyKeyLocked
+		}
+
+		time.Sleep(idempotencyPollInterval)
+	}
+}
+
+// The insert() method marks a key as being processed, taking over the key if it has expired. It reports whether the key was claimed.
+func (m IdempotencyModel) insert(userID int64, key string, fingerprint []byte) (bool, error) {
+	query := `
+			INSERT INTO idempotency_keys (user_id, key, fingerprint, expiry)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, key) DO UPDATE
+			SET fingerprint = EXCLUDED.fingerprint, status = NULL, header = NULL, body = NULL, created_at = NOW(), expiry = EXCLUDED.expiry
+			WHERE idempotency_keys.expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, key, fingerprint, time.Now().Add(idempotencyLease))
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// The get() method returns the stored response for a key, which is nil if the key is still being processed, along with the fingerprint of
+// the request which used the key.
+func (m IdempotencyModel) get(userID int64, key string) (*IdempotentResponse, []byte, error) {
+	query := `
+			SELECT fingerprint, status, header, body
+			FROM idempotency_keys
+			WHERE user_id = $1 AND key = $2`
+
+	var (
+		fingerprint []byte
+		status      sql.NullInt32
+		header      []byte
+		body        []byte
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, key).Scan(&fingerprint, &status, &header, &body)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	if !status.Valid {
+		return nil, fingerprint, nil
+	}
+
+	response := &IdempotentResponse{Status: int(status.Int32), Body: body}
+
+	err = json.Unmarshal(header, &response.Header)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return response, fingerprint, nil
+}
+
+// The Save() method stores the response to the request which claimed the key, and keeps it until the TTL expires.
+func (k *IdempotencyKey) Save(response *IdempotentResponse) error {
+	header, err := json.Marshal(response.Header)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			UPDATE idempotency_keys
+			SET status = $1, header = $2, body = $3, expiry = $4
+			WHERE user_id = $5 AND key = $6 AND status IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{response.Status, header, response.Body, time.Now().Add(k.ttl), k.userID, k.key}
+
+	result, err := k.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// If the lease expired before the response was saved, another request may have claimed the key.
+	if rowsAffected == 0 {
+		return ErrIdempotencyKeyLocked
+	}
+
+	return nil
+}
+
+// The Release() method removes a key which is being processed without storing a response, so that the request can be retried.
+func (k *IdempotencyKey) Release() error {
+	query := `DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND status IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := k.db.ExecContext(ctx, query, k.userID, k.key)
+	return err
+}
+
+// The DeleteExpired() method removes the keys which have expired, and returns the number of keys which were removed.
+func (m IdempotencyModel) DeleteExpired() (int64, error) {
+	query := `DELETE FROM idempotency_keys WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,171 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// MergeableMovieFields are the fields which can be taken from the source movie when two movies are merged. Every other field comes from the
+// target movie, apart from the genres, which are combined.
+var MergeableMovieFields = []string{"title", "year", "runtime", "imdb_id", "tmdb_id", "poster"}
+
+// The MergeMovie() function applies a merge to the target movie: the fields listed in fromSource are copied from the source movie, and the
+// source's genres are added to the target's (after them, without duplicates).
+func MergeMovie(target, source *Movie, fromSource []string) {
+	for _, field := range fromSource {
+		switch field {
+		case "title":
+			target.Title = source.Title
+		case "year":
+			target.Year = source.Year
+		case "runtime":
+			target.Runtime = source.Runtime
+		case "imdb_id":
+			target.IMDbID = source.IMDbID
+		case "tmdb_id":
+			target.TMDbID = source.TMDbID
+		case "poster":
+			target.Poster = source.Poster
+		}
+	}
+
+	for _, genre := range source.Genres {
+		if !containsString(target.Genres, genre) {
+			target.Genres = append(target.Genres, genre)
+		}
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// The Merge() method merges the source movie into the target movie in a single transaction. The target movie should already have been
+// updated with MergeMovie(), and is saved as a new version in the same way as Update(). The source movie's reviews, watchlist entries,
+// credits and revisions are moved to the target; where a user has reviewed or listed both movies, or a person has the same role on both,
+// the target's row is kept and the source's is dropped. The source movie is then kept only as a redirect to the target.
+//
+// Both movies must still have the version numbers they were read with, otherwise ErrEditConflict is returned and nothing is changed.
+func (m MovieModel) Merge(target, source *Movie, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Turn the source movie into a redirect. It is hidden in the same way as a movie in the trash, and its external IDs are cleared so that
+	// they can be moved to the target. Its poster is cleared too, so that the files can be removed if the target doesn't use them. Any movies
+	// which were previously merged into the source are redirected straight to the target.
+	query := `
+			UPDATE movies
+			SET merged_into = $1, deleted_at = NOW(), updated_at = NOW(), imdb_id = '', tmdb_id = 0, poster = '', version = version + 1
+			WHERE id = $2 AND version = $3 AND deleted_at IS NULL`
+
+	result, err := tx.ExecContext(ctx, query, target.ID, source.ID, source.Version)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	// Move the dependent rows, and recalculate the rating aggregates of both movies from their reviews.
+	queries := []string{
+		`UPDATE movies SET merged_into = $1 WHERE merged_into = $2`,
+		`DELETE FROM reviews WHERE movie_id = $2 AND user_id IN (SELECT user_id FROM reviews WHERE movie_id = $1)`,
+		`UPDATE reviews SET movie_id = $1 WHERE movie_id = $2`,
+		`DELETE FROM watchlist_entries WHERE movie_id = $2 AND user_id IN (SELECT user_id FROM watchlist_entries WHERE movie_id = $1)`,
+		`UPDATE watchlist_entries SET movie_id = $1 WHERE movie_id = $2`,
+		`DELETE FROM movie_credits WHERE movie_id = $2 AND (person_id, role) IN (SELECT person_id, role FROM movie_credits WHERE movie_id = $1)`,
+		`UPDATE movie_credits SET movie_id = $1 WHERE movie_id = $2`,
+		`UPDATE movie_revisions SET movie_id = $1, merged_from = COALESCE(merged_from, movie_id) WHERE movie_id = $2`,
+	}
+
+	for _, query := range queries {
+		_, err = tx.ExecContext(ctx, query, target.ID, source.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = updateMovieRatings(ctx, tx, source.ID)
+	if err != nil {
+		return err
+	}
+
+	err = updateMovieRatings(ctx, tx, target.ID)
+	if err != nil {
+		return err
+	}
+
+	posterKey := ""
+	if target.Poster != nil {
+		posterKey = PosterKeyFromURL(target.Poster.URL)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE movies SET poster = $1 WHERE id = $2`, posterKey, target.ID)
+	if err != nil {
+		return err
+	}
+
+	// Save the target movie, which checks its version and records the new revision.
+	err = tx.QueryRowContext(ctx, updateMovieQuery, target.updateArgs(userID)...).Scan(&target.Version, &target.UpdatedAt, &target.UpdatedBy)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return externalIDError(err)
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, `SELECT average_rating, ratings_count FROM movies WHERE id = $1`, target.ID).Scan(&target.AverageRating, &target.RatingsCount)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The GetMergedInto() method returns the ID of the movie which a movie has been merged into. If the movie hasn't been merged into another one,
+// ErrRecordNotFound is returned.
+func (m MovieModel) GetMergedInto(id int64) (int64, error) {
+	if id < 1 {
+		return 0, ErrRecordNotFound
+	}
+
+	query := `SELECT merged_into FROM movies WHERE id = $1 AND merged_into IS NOT NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var target int64
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&target)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return target, nil
+}
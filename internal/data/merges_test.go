@@ -0,0 +1,103 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeMovie(t *testing.T) {
+	newMovies := func() (*Movie, *Movie) {
+		target := &Movie{
+			ID:      1,
+			Title:   "Moana",
+			Year:    2016,
+			Runtime: 107,
+			Genres:  []string{"animation", "adventure"},
+			IMDbID:  "tt3521164",
+			Poster:  &Poster{URL: "/v1/posters/target/original.jpg"},
+			Version: 3,
+		}
+		source := &Movie{
+			ID:      2,
+			Title:   "Vaiana",
+			Year:    2017,
+			Runtime: 113,
+			Genres:  []string{"adventure", "comedy"},
+			TMDbID:  277834,
+			Poster:  &Poster{URL: "/v1/posters/source/original.jpg"},
+			Version: 5,
+		}
+		return target, source
+	}
+
+	tests := []struct {
+		name       string
+		fromSource []string
+		want       func(target, source *Movie) *Movie
+	}{
+		{
+			name: "nothing from source",
+			want: func(target, source *Movie) *Movie {
+				target.Genres = []string{"animation", "adventure", "comedy"}
+				return target
+			},
+		},
+		{
+			name:       "title and year from source",
+			fromSource: []string{"title", "year"},
+			want: func(target, source *Movie) *Movie {
+				target.Title, target.Year = source.Title, source.Year
+				target.Genres = []string{"animation", "adventure", "comedy"}
+				return target
+			},
+		},
+		{
+			name:       "every field from source",
+			fromSource: MergeableMovieFields,
+			want: func(target, source *Movie) *Movie {
+				target.Title, target.Year, target.Runtime = source.Title, source.Year, source.Runtime
+				target.IMDbID, target.TMDbID, target.Poster = source.IMDbID, source.TMDbID, source.Poster
+				target.Genres = []string{"animation", "adventure", "comedy"}
+				return target
+			},
+		},
+		{
+			name:       "unknown fields are ignored",
+			fromSource: []string{"id", "version", "genres"},
+			want: func(target, source *Movie) *Movie {
+				target.Genres = []string{"animation", "adventure", "comedy"}
+				return target
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, source := newMovies()
+			MergeMovie(target, source, tt.fromSource)
+
+			wantTarget, wantSource := newMovies()
+			want := tt.want(wantTarget, wantSource)
+
+			if !reflect.DeepEqual(target, want) {
+				t.Errorf("got %+v; want %+v", target, want)
+			}
+
+			// The source movie is left alone.
+			if _, unchanged := newMovies(); !reflect.DeepEqual(source, unchanged) {
+				t.Errorf("source changed to %+v", source)
+			}
+		})
+	}
+}
+
+func TestMergeMovieGenresWithoutDuplicates(t *testing.T) {
+	target := &Movie{Genres: []string{"drama"}}
+	source := &Movie{Genres: []string{"drama", "drama", "crime"}}
+
+	MergeMovie(target, source, nil)
+
+	if want := []string{"drama", "crime"}; !reflect.DeepEqual(target.Genres, want) {
+		t.Errorf("got %v; want %v", target.Genres, want)
+	}
+}
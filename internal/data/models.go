@@ -13,18 +13,32 @@ var (
 
 // Create a Models struct which wraps the models.
 type Models struct {
+	Credits     CreditModel
+	Genres      GenreModel
+	Idempotency IdempotencyModel
 	Movies      MovieModel
+	Revisions   MovieRevisionModel
+	People      PersonModel
 	Permissions PermissionModel
+	Reviews     ReviewModel
 	Tokens      TokenModel
 	Users       UserModel
+	Watchlists  WatchlistModel
 }
 
 // For ease of use, we also add a New() method which returns a Models struct containing the initialized models.
 func NewModels(db *sql.DB) Models {
 	return Models{
+		Credits:     CreditModel{DB: db},
+		Genres:      GenreModel{DB: db},
+		Idempotency: IdempotencyModel{DB: db},
 		Movies:      MovieModel{DB: db},
+		Revisions:   MovieRevisionModel{DB: db},
+		People:      PersonModel{DB: db},
 		Permissions: PermissionModel{DB: db},
+		Reviews:     ReviewModel{DB: db},
 		Tokens:      TokenModel{DB: db},
 		Users:       UserModel{DB: db},
+		Watchlists:  WatchlistModel{DB: db},
 	}
 }
@@ -0,0 +1,198 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// The MovieFilters struct holds the filters which can be applied when listing or exporting movies. Zero values mean that the filter isn't used.
+type MovieFilters struct {
+	Title            string     // search term for the title
+	SearchMode       string     // how the title is matched: "exact" (whole words, the default), "prefix" or "fuzzy"
+	Language         string     // text search configuration used for exact and prefix searches
+	LanguageSafelist []string   // the text search configurations with a title index
+	Genres           []string   // movies must have all of these genres
+	GenresAny        []string   // movies must have at least one of these genres
+	ExcludeGenres    []string   // movies must have none of these genres
+	Person           int64      // movies must credit the person with this ID, in any role
+	CreatedBy        int64      // movies must have been created by the user with this ID
+	Status           string     // movies must have this status
+	Visibility       Visibility // movies must be visible to the user
+	YearMin          int        // inclusive
+	YearMax          int        // inclusive
+	RuntimeMin       int        // inclusive, in minutes
+	RuntimeMax       int        // inclusive, in minutes
+	CreatedAfter     time.Time  // exclusive
+	CreatedBefore    time.Time  // exclusive
+}
+
+func ValidateMovieFilters(v *validator.Validator, f MovieFilters) {
+	v.Check(validator.In(f.SearchMode, "exact", "prefix", "fuzzy"), "search_mode", "invalid search mode value")
+	v.Check(validator.In(f.Language, f.LanguageSafelist...), "lang", "unsupported text search configuration")
+
+	if f.YearMin != 0 {
+		v.Check(f.YearMin >= 1888, "year_min", "must be greater than 1888")
+	}
+	if f.YearMax != 0 {
+		v.Check(f.YearMax >= 1888, "year_max", "must be greater than 1888")
+	}
+	if f.YearMin != 0 && f.YearMax != 0 {
+		v.Check(f.YearMin <= f.YearMax, "year_min", "must not be greater than year_max")
+	}
+
+	v.Check(f.RuntimeMin >= 0, "runtime_min", "must be a positive integer")
+	v.Check(f.RuntimeMax >= 0, "runtime_max", "must be a positive integer")
+	if f.RuntimeMin != 0 && f.RuntimeMax != 0 {
+		v.Check(f.RuntimeMin <= f.RuntimeMax, "runtime_min", "must not be greater than runtime_max")
+	}
+
+	if !f.CreatedAfter.IsZero() && !f.CreatedBefore.IsZero() {
+		v.Check(f.CreatedAfter.Before(f.CreatedBefore), "created_after", "must be before created_before")
+	}
+
+	v.Check(len(f.GenresAny) <= 20, "genres_any", "must not contain more than 20 genres")
+	v.Check(len(f.ExcludeGenres) <= 20, "exclude_genres", "must not contain more than 20 genres")
+
+	v.Check(f.Person >= 0, "person", "must be a positive integer")
+
+	if f.Status != "" {
+		v.Check(validator.In(f.Status, MovieStatuses...), "status", "invalid status value")
+	}
+}
+
+// The where() method builds the SQL conditions for the filters, joined with AND, along with their placeholder arguments. Only the filters which
+// are in use are included, so that PostgreSQL can make the best use of the indexes. The placeholders are numbered from 1 and the values are only
+// ever passed as arguments, never interpolated into the query. Movies in the trash are always excluded. If there is a title search term it is
+// always the first placeholder, which score() relies on.
+func (f MovieFilters) where() (string, []interface{}) {
+	conditions := []string{"deleted_at IS NULL"}
+	args := []interface{}{}
+
+	// The add() function appends a condition containing a single %d verb, which is replaced with the number of the placeholder for value.
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	// Exact searches use full-text search to find movies containing all of the words in the title. Prefix searches also match words which
+	// start with the search terms, using the same index. Fuzzy searches use the pg_trgm word similarity operator, which tolerates typos.
+	//
+	// The text search configuration is interpolated into the query as a literal, rather than passed as an argument, so that the to_tsvector()
+	// expression matches the expression index for that language.
+	if f.Title != "" {
+		lang := pq.QuoteLiteral(f.language())
+
+		switch f.SearchMode {
+		case "prefix":
+			add("to_tsvector("+lang+", title) @@ to_tsquery("+lang+", $%d)", prefixQuery(f.Title))
+		case "fuzzy":
+			add("$%d <%% title", f.Title)
+		default:
+			add("to_tsvector("+lang+", title) @@ plainto_tsquery("+lang+", $%d)", f.Title)
+		}
+	}
+	if len(f.Genres) > 0 {
+		add("genres @> $%d", pq.Array(f.Genres))
+	}
+	if len(f.GenresAny) > 0 {
+		add("genres && $%d", pq.Array(f.GenresAny))
+	}
+	if len(f.ExcludeGenres) > 0 {
+		add("NOT genres && $%d", pq.Array(f.ExcludeGenres))
+	}
+	if f.Person != 0 {
+		add("EXISTS (SELECT 1 FROM movie_credits WHERE movie_credits.movie_id = movies.id AND movie_credits.person_id = $%d)", f.Person)
+	}
+	if condition, arg := f.Visibility.condition(); condition != "" {
+		add(condition, arg)
+	}
+	if f.Status != "" {
+		add("status = $%d", f.Status)
+	}
+	if f.CreatedBy != 0 {
+		add("created_by = $%d", f.CreatedBy)
+	}
+	if f.YearMin != 0 {
+		add("year >= $%d", f.YearMin)
+	}
+	if f.YearMax != 0 {
+		add("year <= $%d", f.YearMax)
+	}
+	if f.RuntimeMin != 0 {
+		add("runtime >= $%d", f.RuntimeMin)
+	}
+	if f.RuntimeMax != 0 {
+		add("runtime <= $%d", f.RuntimeMax)
+	}
+	if !f.CreatedAfter.IsZero() {
+		add("created_at > $%d", f.CreatedAfter)
+	}
+	if !f.CreatedBefore.IsZero() {
+		add("created_at < $%d", f.CreatedBefore)
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// The prefixQuery() function converts a search term into a tsquery which matches all of the words in it as prefixes, for example
+// "godf par" becomes "godf:* & par:*". Anything other than letters and digits is treated as a separator, so the result is always a
+// syntactically valid tsquery.
+func prefixQuery(term string) string {
+	words := strings.FieldsFunc(strings.ToLower(term), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+
+	return strings.Join(words, " & ")
+}
+
+// The score() method returns a SQL expression for the relevance of a movie to the title search term, or NULL if there isn't one. Exact and
+// prefix searches are ranked with ts_rank_cd(). For the simple configuration this uses the stored search_vector column, in which title matches
+// carry more weight than genre matches. The stored column can only be in one language, so for any other configuration the title is converted
+// to a tsvector as the results are ranked. Fuzzy searches are ranked by their trigram word similarity.
+func (f MovieFilters) score() string {
+	if f.Title == "" {
+		return "NULL::real"
+	}
+
+	if f.SearchMode == "fuzzy" {
+		return "word_similarity($1, title)"
+	}
+
+	lang := pq.QuoteLiteral(f.language())
+
+	vector := "search_vector"
+	if f.language() != "simple" {
+		vector = "to_tsvector(" + lang + ", title)"
+	}
+
+	if f.SearchMode == "prefix" {
+		return "ts_rank_cd(" + vector + ", to_tsquery(" + lang + ", $1))"
+	}
+
+	return "ts_rank_cd(" + vector + ", plainto_tsquery(" + lang + ", $1))"
+}
+
+// Check that the text search configuration matches one of the entries in the safelist, in the same way as Filters.sortColumn().
+// If no configuration was given, the simple configuration is used.
+func (f MovieFilters) language() string {
+	if f.Language == "" {
+		return "simple"
+	}
+
+	for _, safeValue := range f.LanguageSafelist {
+		if f.Language == safeValue {
+			return f.Language
+		}
+	}
+
+	panic("unsafe lang parameter: " + f.Language)
+}
@@ -0,0 +1,26 @@
+package data
+
+import "testing"
+
+func TestPrefixQuery(t *testing.T) {
+	tests := []struct {
+		term string
+		want string
+	}{
+		{"star", "star:*"},
+		{"Star Wa", "star:* & wa:*"},
+		{"  star   wars  ", "star:* & wars:*"},
+		{"wall-e", "wall:* & e:*"},
+		{"amélie", "amélie:*"},
+		{"2001: a space", "2001:* & a:* & space:*"},
+		{"it's & (not) | !tsquery:*", "it:* & s:* & not:* & tsquery:*"},
+		{"", ""},
+		{"!!!", ""},
+	}
+
+	for _, tt := range tests {
+		if got := prefixQuery(tt.term); got != tt.want {
+			t.Errorf("prefixQuery(%q) = %q; want %q", tt.term, got, tt.want)
+		}
+	}
+}
@@ -3,8 +3,12 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -15,15 +19,40 @@ import (
 type Movie struct {
 	ID        int64     `json:"id"`             // Unique integer ID for the movie
 	CreatedAt time.Time `json:"-"`              // Timestamp for when the movie is added to our database [Use the - directive]
+	UpdatedAt time.Time `json:"-"`              // Timestamp for when the movie was last modified, which is used for the Last-Modified header
 	Title     string    `json:"title"`          // Movie title
 	Year      int32     `json:"year,omitempty"` // Movie release year [Add the omitempty directive]
 	// if the Runtime field has the underlying value 0, then it will be considered empty and omitted and the MarshalJSON() method won't be called at all.
 	Runtime Runtime  `json:"runtime,omitempty"` // Movie runtime (in minutes) [Add the omitempty directive]
 	Genres  []string `json:"genres,omitempty"`  // Slice of genres for the movie (romance, comedy, etc.) [Add the omitempty directive]
 	Version int32    `json:"version"`           // The version number starts at 1 and will be incremented each time the movie information is updated
+	// Optional IDs of the movie in external catalogues. Each ID can only belong to one movie.
+	IMDbID string `json:"imdb_id,omitempty"` // for example "tt0113277"
+	TMDbID int64  `json:"tmdb_id,omitempty"` // for example 949
+	// The IDs of the users who created the movie and last changed it. These are null for movies which pre-date ownership tracking, or
+	// if the user has been deleted.
+	CreatedBy *int64 `json:"created_by"`
+	UpdatedBy *int64 `json:"updated_by"`
+	// The movie's place in the publishing workflow (see MovieStatuses). A published movie with a publish_at time in the future is scheduled,
+	// and isn't visible to everyone until then.
+	Status    string     `json:"status"`
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+	// The average rating and number of ratings from the movie's reviews. These are kept up to date by ReviewModel.
+	AverageRating float64 `json:"average_rating"`
+	RatingsCount  int32   `json:"ratings_count"`
+	// The URLs of the movie's poster and its thumbnails, or nil if no poster has been uploaded.
+	Poster *Poster `json:"poster,omitempty"`
+	// Timestamp for when the movie was moved to the trash. This is only ever set for movies returned by GetAllDeleted().
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Relevance of the movie to the title search term, or its similarity to another movie. This is only ever set for movies returned by GetAll()
+	// with a search term, or by GetSimilar().
+	Score *float64 `json:"score,omitempty"`
+	// The people credited on the movie. This is only ever set by showMovieHandler() when the client asks for the credits to be included.
+	Credits []*Credit `json:"credits,omitempty"`
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
+// The ValidateMovie() function checks the data for a movie. The genres of the movie must all be slugs from the given list of known genres.
+func ValidateMovie(v *validator.Validator, movie *Movie, genres []*Genre) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
 
@@ -38,6 +67,34 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+	validateGenres(v, movie.Genres, genres)
+
+	if movie.IMDbID != "" {
+		v.Check(validator.Matches(movie.IMDbID, imdbIDRX), "imdb_id", "must be an IMDb title ID, such as tt0113277")
+	}
+	v.Check(movie.TMDbID >= 0, "tmdb_id", "must be a positive integer")
+}
+
+// The imdbIDRX pattern matches IMDb title IDs, which are "tt" followed by at least 7 digits.
+var imdbIDRX = regexp.MustCompile("^tt[0-9]{7,10}$")
+
+// Define custom errors which are returned when a movie is inserted or updated with an external ID that belongs to another movie.
+var (
+	ErrDuplicateIMDbID = errors.New("duplicate IMDb ID")
+	ErrDuplicateTMDbID = errors.New("duplicate TMDB ID")
+)
+
+// The externalIDError() function converts a unique constraint violation on one of the external ID columns into ErrDuplicateIMDbID or
+// ErrDuplicateTMDbID. Any other error is returned unchanged.
+func externalIDError(err error) error {
+	switch {
+	case err.Error() == `pq: duplicate key value violates unique constraint "movies_imdb_id_key"`:
+		return ErrDuplicateIMDbID
+	case err.Error() == `pq: duplicate key value violates unique constraint "movies_tmdb_id_key"`:
+		return ErrDuplicateTMDbID
+	default:
+		return err
+	}
 }
 
 // Define a MovieModel struct type which wraps a sql.DB connection pool.
@@ -45,29 +102,133 @@ type MovieModel struct {
 	DB *sql.DB
 }
 
-// The Insert() method accepts a pointer to a movie struct, which should contain the data for the new record.
-func (m MovieModel) Insert(movie *Movie) error {
-	// Define the SQL query for inserting a new record in the system-generated data.
-	query := `INSERT INTO movies (title, year, runtime, genres) VALUES ($1, $2, $3, $4) RETURNING id, created_at, version`
-
-	// Create an args slice containing the values for the placeholder parameters from the movie struct
-	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
-
+// The Insert() method accepts a pointer to a movie struct, which should contain the data for the new record, and the ID of the user who is creating it.
+func (m MovieModel) Insert(movie *Movie, userID int64) error {
 	// Create a context with a 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	// Use the QueryRow() method to execute the SQL query on our connection pool, passing in the args slice as a variadic parameter
-	// and scanning the system-generated id, created_at and version values into the movie struct.
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	// and scanning the system-generated id, created_at, updated_at, version and ownership values into the movie struct.
+	err := m.DB.QueryRowContext(ctx, insertMovieQuery, movie.insertArgs(userID)...).Scan(movie.insertDest()...)
+	if err != nil {
+		return externalIDError(err)
+	}
+
+	return nil
+}
+
+// Define the SQL query for inserting a new record and returning the system-generated data. This is shared by Insert() and MovieImport.Insert().
+// The first revision of the movie is recorded in the movie_revisions table by the same statement, along with the ID of the acting user ($7).
+const insertMovieQuery = `
+		WITH movie AS (
+			INSERT INTO movies (title, year, runtime, genres, imdb_id, tmdb_id, created_by, updated_by) VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+			RETURNING id, created_at, updated_at, title, year, runtime, genres, imdb_id, tmdb_id, version, created_by, updated_by, status
+		), revision AS (
+			INSERT INTO movie_revisions (movie_id, version, title, year, runtime, genres, imdb_id, tmdb_id, user_id, created_at)
+			SELECT id, version, title, year, runtime, genres, imdb_id, tmdb_id, $7, created_at FROM movie
+		)
+		SELECT id, created_at, updated_at, version, created_by, updated_by, status FROM movie`
+
+// The insertArgs() method returns the values for the placeholder parameters in insertMovieQuery.
+func (movie *Movie) insertArgs(userID int64) []interface{} {
+	return []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.IMDbID, movie.TMDbID, userID}
+}
+
+// The insertDest() method returns the destinations for the values returned by insertMovieQuery.
+func (movie *Movie) insertDest() []interface{} {
+	return []interface{}{&movie.ID, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version, &movie.CreatedBy, &movie.UpdatedBy, &movie.Status}
+}
+
+// MovieImport wraps a database transaction which is used to insert movies in bulk. Use MovieModel.BeginImport() to create one, and make sure
+// that either Commit() or Rollback() is called when you are done with it.
+type MovieImport struct {
+	tx     *sql.Tx
+	stmt   *sql.Stmt
+	userID int64
+}
+
+// The BeginImport() method starts a new transaction and prepares the insert statement which will be used for every movie in the import.
+// The movies' first revisions are recorded against the provided user ID.
+func (m MovieModel) BeginImport(userID int64) (*MovieImport, error) {
+	// Note that we don't use a context with a timeout here, as cancelling the context would roll back the transaction.
+	// Instead, each call to MovieImport.Insert() has its own timeout.
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := tx.Prepare(insertMovieQuery)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return &MovieImport{tx: tx, stmt: stmt, userID: userID}, nil
+}
+
+// The Insert() method inserts a batch of movies within the import transaction, updating each movie struct with the system-generated information.
+// Each movie is inserted under its own savepoint, so that a movie which can't be inserted (for example because its IMDb ID is already in use)
+// doesn't abort the transaction for the others. The returned slice holds the error for each movie, in the same order, and is nil for the movies
+// which were inserted. A non-nil error means that the transaction can no longer be used, and should be rolled back.
+func (i *MovieImport) Insert(movies []*Movie) ([]error, error) {
+	// Create a context with a 10-second timeout for the whole batch.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	errs := make([]error, len(movies))
+
+	for n, movie := range movies {
+		_, err := i.tx.ExecContext(ctx, "SAVEPOINT import_row")
+		if err != nil {
+			return nil, err
+		}
+
+		err = i.stmt.QueryRowContext(ctx, movie.insertArgs(i.userID)...).Scan(movie.insertDest()...)
+		if err != nil {
+			errs[n] = externalIDError(err)
+
+			// Undo the failed insert. If this fails too (for example because the context has timed out), the transaction is unusable.
+			_, err = i.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT import_row")
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		_, err = i.tx.ExecContext(ctx, "RELEASE SAVEPOINT import_row")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return errs, nil
 }
 
-func (m MovieModel) Get(id int64) (*Movie, error) {
+// The Commit() method commits all of the movies inserted in the import.
+func (i *MovieImport) Commit() error {
+	i.stmt.Close()
+	return i.tx.Commit()
+}
+
+// The Rollback() method discards all of the movies inserted in the import.
+func (i *MovieImport) Rollback() error {
+	i.stmt.Close()
+	return i.tx.Rollback()
+}
+
+// The Get() method returns a specific movie, if it is visible. If any fields are given, only those columns are read from the database (along
+// with the columns needed for the ETag and Last-Modified headers); otherwise every column is read.
+func (m MovieModel) Get(id int64, vis Visibility, fields ...string) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
+
+	columns := movieColumns(fields)
+	args := []interface{}{id}
+
 	// Define the SQL query for retrieving the movie data.
-	query := `SELECT id, created_at, title, year, runtime, genres, version FROM movies WHERE id = $1`
+	query := fmt.Sprintf(`SELECT %s FROM movies WHERE id = $1 AND deleted_at IS NULL AND %s`, strings.Join(columns, ", "), vis.where(&args))
 
 	// Declare a Movie struct to hold the data returned by the query.
 	var movie Movie
@@ -80,15 +241,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 
 	// Execute the query using the QueryRow() method, passing in the provided id value as a placeholder parameter,
 	// and scan the response data into the fields of the Movie struct.
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&movie.ID,
-		&movie.CreatedAt,
-		&movie.Title,
-		&movie.Year,
-		&movie.Runtime,
-		pq.Array(&movie.Genres),
-		&movie.Version,
-	)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(movie.scanDest(columns)...)
 
 	// Handle any errors. If there was no matching movie found, Scan() will return a sql.ErrNoRows error.
 	// We check for this and return our custom ErrRecordNotFound error instead.
@@ -105,50 +258,99 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
-	// Declare the SQL query for updating the record and returning the new version number.
-	query := `
+// The Update() method saves the changes to a movie, recording the new version in the movie_revisions table along with the ID of the user making the change.
+func (m MovieModel) Update(movie *Movie, userID int64) error {
+	// Create a context with a 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Use the QueryRowContext() method to execute the query, passing in the args slice as a variadic parameter and scanning the new version and
+	// updated_at values into the movie struct.
+	// If no matching row could be found, we know the movie version has changed (or the record has been deleted) and we return our custom ErrEditConflict error.
+	err := m.DB.QueryRowContext(ctx, updateMovieQuery, movie.updateArgs(userID)...).Scan(&movie.Version, &movie.UpdatedAt, &movie.UpdatedBy)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return externalIDError(err)
+		}
+	}
+
+	return nil
+}
+
+// Define the SQL query for updating a record, recording the revision, and returning the new version number. This is shared by Update() and Merge().
+const updateMovieQuery = `
+		WITH movie AS (
 			UPDATE movies
-			SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1 WHERE id = $5 AND version = $6
-			RETURNING version`
+			SET title = $1, year = $2, runtime = $3, genres = $4, imdb_id = $5, tmdb_id = $6, updated_at = NOW(), updated_by = $9,
+				version = version + 1
+			WHERE id = $7 AND version = $8 AND deleted_at IS NULL
+			RETURNING id, title, year, runtime, genres, imdb_id, tmdb_id, version, updated_at, updated_by
+		), revision AS (
+			INSERT INTO movie_revisions (movie_id, version, title, year, runtime, genres, imdb_id, tmdb_id, user_id)
+			SELECT id, version, title, year, runtime, genres, imdb_id, tmdb_id, $9 FROM movie
+		)
+		SELECT version, updated_at, updated_by FROM movie`
 
-	// Create an args slice containing the values for the placeholder parameters.
-	args := []interface{}{
+// The updateArgs() method returns the values for the placeholder parameters in updateMovieQuery.
+func (movie *Movie) updateArgs(userID int64) []interface{} {
+	return []interface{}{
 		movie.Title,
 		movie.Year,
 		movie.Runtime,
 		pq.Array(movie.Genres),
+		movie.IMDbID,
+		movie.TMDbID,
 		movie.ID,
 		movie.Version,
+		userID,
 	}
+}
+
+// The GetOwner() method returns the ID of the user who created a movie, or 0 if that isn't known.
+func (m MovieModel) GetOwner(id int64) (int64, error) {
+	if id < 1 {
+		return 0, ErrRecordNotFound
+	}
+
+	query := `SELECT COALESCE(created_by, 0) FROM movies WHERE id = $1 AND deleted_at IS NULL`
 
-	// Create a context with a 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	// Use the QueryRowContext() method to execute the query, passing in the args slice as a variadic parameter and scanning the new version value into the movie struct.
-	// If no matching row could be found, we know the movie version has changed (or the record has been deleted) and we return our custom ErrEditConflict error.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	var owner int64
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&owner)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
+			return 0, ErrRecordNotFound
 		default:
-			return err
+			return 0, err
 		}
 	}
 
-	return nil
+	return owner, nil
 }
 
-func (m MovieModel) Delete(id int64) error {
+// The Delete() method moves a movie to the trash by setting its deleted_at timestamp. The movie can be brought back with Restore(),
+// or permanently deleted with Purge(). Movies which have been merged into another movie also have a deleted_at timestamp, but they aren't
+// in the trash: they are kept as redirects until the movie they were merged into is purged.
+//
+// If version isn't zero, the movie is only deleted if it still has that version, in the same way as Update(), and ErrEditConflict is returned
+// if it doesn't match (or the movie no longer exists). This lets a client's If-Match precondition be checked in the same statement.
+func (m MovieModel) Delete(id int64, version int32) error {
 	// Return an ErrRecordNotFound error if the movie ID is less than 1.
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	// Construct the SQL query to delete the record.
-	query := `DELETE FROM movies WHERE id = $1`
+	// Construct the SQL query to soft-delete the record.
+	query := `
+			UPDATE movies SET deleted_at = NOW(), updated_at = NOW()
+			WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2)`
 
 	// Create a context with a 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -156,7 +358,7 @@ func (m MovieModel) Delete(id int64) error {
 
 	// Execute the SQL query using the Exec() method, passing in the id variable as the value for the placeholder parameter.
 	// The Exec() method returns a sql.Result object.
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, id, version)
 	if err != nil {
 		return err
 	}
@@ -169,76 +371,628 @@ func (m MovieModel) Delete(id int64) error {
 
 	// If no rows were affected, we know that the movies table didn't contain a record  with the provided ID at the moment we tried to delete it.
 	if rowsAffected == 0 {
+		if version != 0 {
+			return ErrEditConflict
+		}
 		return ErrRecordNotFound
 	}
 
 	return nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
-	// Construct the SQL query to retrieve all movie records.
-	// Use full-text search for the title filter.
-	// Add an ORDER BY clause and interpolate the sort column and direction.
-	query := fmt.Sprintf(`
-			SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
-			FROM movies
-			WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '') AND (genres @> $2 OR $2 = '{}')
-			ORDER BY %s %s, id ASC
-			LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+// The Restore() method takes a movie out of the trash, returning ErrRecordNotFound if there is no trashed movie with the provided ID.
+func (m MovieModel) Restore(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `UPDATE movies SET deleted_at = NULL, updated_at = NOW() WHERE id = $1 AND deleted_at IS NOT NULL AND merged_into IS NULL`
 
-	// Create a context with a 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// The Purge() method permanently deletes a movie. Only movies which are already in the trash can be purged. The keys of the posters used by
+// the movie, and by any movies which were merged into it, are returned so that their files can be removed if nothing else uses them.
+func (m MovieModel) Purge(id int64) ([]string, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	purged, posters, err := m.purge(ctx, "id = $1 AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return nil, err
+	}
+
+	if purged == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	return posters, nil
+}
+
+// The PurgeDeletedBefore() method permanently deletes every movie which was moved to the trash before the cutoff time, returning the number
+// of movies that were purged and the keys of their posters, in the same way as Purge().
+func (m MovieModel) PurgeDeletedBefore(cutoff time.Time) (int64, []string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return m.purge(ctx, "deleted_at < $1", cutoff)
+}
+
+// The purge() method deletes the movies which match the condition (apart from merged movies, which are deleted along with the movie they were
+// merged into by the foreign key). It returns the number of movies which matched, and the distinct keys of the posters used by them and by
+// the movies merged into them. The outer SELECT sees the movies table as it was before the DELETE, so the merged movies can still be read.
+func (m MovieModel) purge(ctx context.Context, condition string, args ...interface{}) (int64, []string, error) {
+	query := fmt.Sprintf(`
+			WITH purged AS (
+				DELETE FROM movies
+				WHERE %s AND merged_into IS NULL
+				RETURNING id, poster
+			)
+			SELECT true, poster FROM purged
+			UNION ALL
+			SELECT false, poster FROM movies WHERE merged_into IN (SELECT id FROM purged) AND poster <> ''`, condition)
 
-	// Use QueryContext() to execute the query. This returns a sql.Rows resultset containing the result.
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, Metadata{}, err // Update this to return an empty Metadata struct.
+		return 0, nil, err
 	}
+	defer rows.Close()
 
-	// Importantly, defer a call to rows.Close() to ensure that the resultset is closed before GetAll() returns.
+	var purged int64
+	posters := []string{}
+
+	for rows.Next() {
+		var (
+			matched bool
+			poster  string
+		)
+
+		err := rows.Scan(&matched, &poster)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if matched {
+			purged++
+		}
+		if poster != "" && !containsString(posters, poster) {
+			posters = append(posters, poster)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	return purged, posters, nil
+}
+
+// The GetAllDeleted() method returns a page of the movies which are currently in the trash.
+func (m MovieModel) GetAllDeleted(filters Filters) ([]*Movie, Metadata, error) {
+	query := fmt.Sprintf(`
+			SELECT count(*) OVER(), id, created_at, updated_at, title, year, runtime, genres, version, created_by, updated_by, status, publish_at,
+				average_rating, ratings_count, poster, deleted_at
+			FROM movies
+			WHERE deleted_at IS NOT NULL AND merged_into IS NULL
+			ORDER BY %s %s, id ASC
+			LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
 	defer rows.Close()
 
-	// Declare a totalRecords variable.
 	totalRecords := 0
-
-	// Initialize an empty slice to hold the movie data.
 	movies := []*Movie{}
 
-	// Use rows.Next to iterate through the rows in the resultset.
 	for rows.Next() {
-		// Initialize an empty Movie struct to hold the data for an individual movie.
 		var movie Movie
 
-		// Scan the values from the row into the Movie struct.
 		err := rows.Scan(
-			&totalRecords, // Scan the count from the window function into totalRecords.
+			&totalRecords,
 			&movie.ID,
 			&movie.CreatedAt,
+			&movie.UpdatedAt,
 			&movie.Title,
 			&movie.Year,
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.CreatedBy,
+			&movie.UpdatedBy,
+			&movie.Status,
+			&movie.PublishAt,
+			&movie.AverageRating,
+			&movie.RatingsCount,
+			posterDest{&movie.Poster},
+			&movie.DeletedAt,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// The allMovieColumns variable lists the columns which can be read into a Movie struct, in the order that they are selected.
+var allMovieColumns = []string{"id", "created_at", "updated_at", "title", "year", "runtime", "genres", "version", "imdb_id", "tmdb_id", "created_by",
+	"updated_by", "status", "publish_at", "average_rating", "ratings_count", "poster"}
+
+// The conditionalMovieColumns are always read, whatever fields are requested, because they are needed to generate the ETag and
+// Last-Modified headers.
+var conditionalMovieColumns = []string{"id", "updated_at", "version", "average_rating", "ratings_count"}
+
+// The movieColumns() function returns the columns to select for a sparse fieldset. If no fields are given every column is returned. Otherwise
+// the result only includes the requested fields, the conditionalMovieColumns and any other required columns. The names are taken from
+// allMovieColumns rather than from the arguments, so user input is never interpolated into the query.
+func movieColumns(fields []string, required ...string) []string {
+	if len(fields) == 0 {
+		return allMovieColumns
+	}
+
+	columns := []string{}
+	for _, column := range allMovieColumns {
+		if validator.In(column, fields...) || validator.In(column, conditionalMovieColumns...) || validator.In(column, required...) {
+			columns = append(columns, column)
+		}
+	}
+
+	return columns
+}
+
+// The scanDest() method returns the destinations that rows.Scan() should use for the given columns.
+func (movie *Movie) scanDest(columns []string) []interface{} {
+	dest := make([]interface{}, len(columns))
+
+	for i, column := range columns {
+		switch column {
+		case "id":
+			dest[i] = &movie.ID
+		case "created_at":
+			dest[i] = &movie.CreatedAt
+		case "updated_at":
+			dest[i] = &movie.UpdatedAt
+		case "title":
+			dest[i] = &movie.Title
+		case "year":
+			dest[i] = &movie.Year
+		case "runtime":
+			dest[i] = &movie.Runtime
+		case "genres":
+			dest[i] = pq.Array(&movie.Genres)
+		case "version":
+			dest[i] = &movie.Version
+		case "imdb_id":
+			dest[i] = &movie.IMDbID
+		case "tmdb_id":
+			dest[i] = &movie.TMDbID
+		case "created_by":
+			dest[i] = &movie.CreatedBy
+		case "updated_by":
+			dest[i] = &movie.UpdatedBy
+		case "status":
+			dest[i] = &movie.Status
+		case "publish_at":
+			dest[i] = &movie.PublishAt
+		case "average_rating":
+			dest[i] = &movie.AverageRating
+		case "ratings_count":
+			dest[i] = &movie.RatingsCount
+		case "poster":
+			dest[i] = posterDest{&movie.Poster}
+		default:
+			panic("unknown movie column: " + column)
+		}
+	}
+
+	return dest
+}
+
+// The normalizedTitle expression converts a title to lowercase and removes everything except letters and digits, so that "Heat", "HEAT"
+// and "Heat!" are all the same. It must match the expression in the movies_normalized_title_idx index.
+const normalizedTitle = `regexp_replace(lower(%s), '[^[:alnum:]]+', '', 'g')`
+
+// The FindDuplicates() method returns the visible movies (up to 10, and not including those in the trash) which look like duplicates of a new
+// movie with the given title and year, because they have the same year and the same title once it has been normalized.
+func (m MovieModel) FindDuplicates(title string, year int32, vis Visibility) ([]*Movie, error) {
+	args := []interface{}{title, year}
+
+	query := fmt.Sprintf(`
+			SELECT %s
+			FROM movies
+			WHERE deleted_at IS NULL AND year = $2 AND %s = %s AND %s
+			ORDER BY id
+			LIMIT 10`, strings.Join(allMovieColumns, ", "), fmt.Sprintf(normalizedTitle, "title"), fmt.Sprintf(normalizedTitle, "$1"), vis.where(&args))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(movie.scanDest(allMovieColumns)...)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// The GetAll() method returns a page of the movies matching the movie filters. As with Get(), the columns which are read can be
+// narrowed by passing in a list of fields. The sort column is always read as well, because it is needed to generate the next cursor.
+// Any requested facets are calculated over all of the matching movies in the same query, and returned as Aggregations.
+func (m MovieModel) GetAll(movieFilters MovieFilters, filters Filters, facets []string, fields ...string) ([]*Movie, Metadata, Aggregations, error) {
+	columns := movieColumns(fields, filters.sortColumn())
+
+	// Only calculate the total number of matching records with the count(*) OVER() window function if it is needed.
+	totalColumn := "0"
+	if filters.includeTotal() {
+		totalColumn = "count(*) OVER()"
+	}
+
+	// Build the conditions for the movie filters. The LIMIT and OFFSET placeholders come after the filter arguments, followed by
+	// the keyset condition for the cursor (if there is one).
+	where, args := movieFilters.where()
+	n := len(args)
+
+	keyset, keysetArgs := filters.keyset(n + 3)
+
+	// The relevance sort always puts the best matches first.
+	orderBy := fmt.Sprintf("%s %s, id ASC", filters.sortColumn(), filters.sortDirection())
+	if filters.Sort == "relevance" {
+		orderBy = "score DESC, id ASC"
+	}
+
+	// Construct the SQL query to retrieve all movie records. The movies matching the filters are put in a common table expression,
+	// so that the facets can be calculated from the same set of movies as the page of results.
+	// Add an ORDER BY clause and interpolate the sort column and direction.
+	// Note that we fetch one more record than the page size, so that we know whether there is a next page.
+	query := fmt.Sprintf(`
+			WITH matches AS (
+				SELECT * FROM movies WHERE %s
+			)
+			SELECT %s, %s, %s AS score, %s
+			FROM matches
+			WHERE %s
+			ORDER BY %s
+			LIMIT $%d OFFSET $%d`, where, totalColumn, facetsColumn(facets), movieFilters.score(), strings.Join(columns, ", "), keyset, orderBy, n+1, n+2)
+
+	// Create a context with a 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args = append(args, filters.limit()+1, filters.offset())
+	args = append(args, keysetArgs...)
+
+	// Use QueryContext() to execute the query. This returns a sql.Rows resultset containing the result.
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, nil, err
+	}
+
+	// Importantly, defer a call to rows.Close() to ensure that the resultset is closed before GetAll() returns.
+	defer rows.Close()
+
+	// Declare a totalRecords variable, and a byte slice for the facets. Like the total, the facets are repeated on every row.
+	totalRecords := 0
+	var facetsJSON []byte
+
+	// Initialize an empty slice to hold the movie data.
+	movies := []*Movie{}
+
+	// Use rows.Next to iterate through the rows in the resultset.
+	for rows.Next() {
+		// Initialize an empty Movie struct to hold the data for an individual movie.
+		var movie Movie
+
+		// Scan the count from the window function into totalRecords, the facets into facetsJSON and the relevance score into the movie,
+		// followed by the selected columns.
+		err := rows.Scan(append([]interface{}{&totalRecords, &facetsJSON, &movie.Score}, movie.scanDest(columns)...)...)
+		if err != nil {
+			return nil, Metadata{}, nil, err
+		}
+
 		// Add the Movie struct to the slice.
 		movies = append(movies, &movie)
 	}
 
 	// When the rows.Next() loop has finished, call rows.Err() to retrieve any error that was encountered during the iteration.
 	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, nil, err
+	}
+
+	// Decode the facets. As with the metadata, they will be empty if the page is past the end of the results.
+	var aggregations Aggregations
+	if facetsJSON != nil {
+		err = json.Unmarshal(facetsJSON, &aggregations)
+		if err != nil {
+			return nil, Metadata{}, nil, err
+		}
 	}
 
 	// Generate a Metadata struct, passing in the total record count and pagination parameters from the client.
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	// If the total wasn't calculated, we only include the page size.
+	var metadata Metadata
+	switch {
+	case filters.includeTotal():
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	case len(movies) > 0:
+		metadata = Metadata{PageSize: filters.PageSize}
+	}
+
+	// If we got back the extra record then there is another page, so drop the extra record and generate a cursor pointing at the last movie on this page.
+	// Relevance scores aren't stored, so there is no cursor for the relevance sort and the client pages through the results with page numbers.
+	if len(movies) > filters.limit() {
+		movies = movies[:filters.limit()]
+		last := movies[len(movies)-1]
+
+		if filters.Sort != "relevance" {
+			metadata.NextCursor, err = filters.nextCursor(last.sortValue(filters.sortColumn()), last.ID)
+			if err != nil {
+				return nil, Metadata{}, nil, err
+			}
+		}
+	}
 
 	// If everything went OK, then return the slice of movies.
-	return movies, metadata, nil
+	return movies, metadata, aggregations, nil
+}
+
+// The Export() method streams every movie matching the movie filters to fn, one row at a time, ordered by the filters' sort parameter.
+// Unlike GetAll() the results aren't paginated and there is no fixed timeout: the query runs until every row has been read, fn returns an error,
+// or ctx is cancelled (for example, because the client has disconnected).
+func (m MovieModel) Export(ctx context.Context, movieFilters MovieFilters, filters Filters, fn func(*Movie) error) error {
+	where, args := movieFilters.where()
+
+	query := fmt.Sprintf(`
+			SELECT %s
+			FROM movies
+			WHERE %s
+			ORDER BY %s %s, id ASC`, strings.Join(allMovieColumns, ", "), where, filters.sortColumn(), filters.sortDirection())
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// Reuse the same Movie struct for every row, so that memory use stays constant however many rows there are. It is reset before each row,
+	// so that nullable columns such as the poster don't carry over from the previous movie.
+	var movie Movie
+
+	for rows.Next() {
+		movie = Movie{}
+
+		err := rows.Scan(movie.scanDest(allMovieColumns)...)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// The sortValue() method returns the value of the given sort column for the movie, formatted as a string so that it can be stored in a pagination cursor.
+func (movie *Movie) sortValue(column string) string {
+	switch column {
+	case "id":
+		return strconv.FormatInt(movie.ID, 10)
+	case "title":
+		return movie.Title
+	case "year":
+		return strconv.FormatInt(int64(movie.Year), 10)
+	case "runtime":
+		return strconv.FormatInt(int64(movie.Runtime), 10)
+	case "average_rating":
+		// The column has two decimal places, so this is an exact representation of the stored value.
+		return strconv.FormatFloat(movie.AverageRating, 'f', 2, 64)
+	case "ratings_count":
+		return strconv.FormatInt(int64(movie.RatingsCount), 10)
+	}
+
+	panic("unsupported sort column: " + column)
+}
+
+// The TitleSuggestion struct holds a single result from Autocomplete().
+type TitleSuggestion struct {
+	ID    int64   `json:"id"`
+	Title string  `json:"title"`
+	Year  int32   `json:"year"`
+	Score float64 `json:"score"`
+}
+
+// The Autocomplete() method returns up to limit title suggestions for a partially typed search term. Titles which start with the term
+// come first, followed by the rest in order of their pg_trgm word similarity to the term, so small typos still produce suggestions.
+// Both conditions can use the trigram index on the title column. Only visible movies are suggested.
+func (m MovieModel) Autocomplete(term string, limit int, vis Visibility) ([]*TitleSuggestion, error) {
+	// Escape any wildcard characters in the term, so that they are matched literally by ILIKE.
+	prefix := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(term) + "%"
+
+	args := []interface{}{term, prefix, limit}
+
+	query := fmt.Sprintf(`
+			SELECT id, title, year, word_similarity($1, title) AS score
+			FROM movies
+			WHERE deleted_at IS NULL AND ($1 <%% title OR title ILIKE $2) AND %s
+			ORDER BY title ILIKE $2 DESC, score DESC, title ASC
+			LIMIT $3`, vis.where(&args))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*TitleSuggestion{}
+
+	for rows.Next() {
+		var suggestion TitleSuggestion
+
+		err := rows.Scan(&suggestion.ID, &suggestion.Title, &suggestion.Year, &suggestion.Score)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &suggestion)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
+// The GetSimilar() method returns up to limit movies which are similar to the movie with the given ID, most similar first, with their
+// similarity in the Score field. Candidates must share at least one genre or have a similar title, which lets PostgreSQL find them with the
+// GIN indexes on genres and title. Each candidate is then scored out of 1 from the Jaccard index of the two movies' genres (the number of
+// genres they share divided by the number of distinct genres between them), how close their years (within 20) and runtimes (within 60
+// minutes) are, and the trigram similarity of their titles. Genres carry the most weight. Only visible movies are returned.
+func (m MovieModel) GetSimilar(id int64, limit int, vis Visibility) ([]*Movie, error) {
+	args := []interface{}{id, limit}
+
+	query := fmt.Sprintf(`
+			WITH source AS (
+				SELECT title, year, runtime, genres FROM movies WHERE id = $1
+			)
+			SELECT %s, score
+			FROM (
+				SELECT movies.*, (
+					0.5 * cardinality(ARRAY(SELECT unnest(movies.genres) INTERSECT SELECT unnest(source.genres)))
+						/ GREATEST(cardinality(ARRAY(SELECT unnest(movies.genres) UNION SELECT unnest(source.genres))), 1)::numeric
+					+ 0.2 * GREATEST(0, 1 - abs(movies.year - source.year) / 20.0)
+					+ 0.1 * GREATEST(0, 1 - abs(movies.runtime - source.runtime) / 60.0)
+					+ 0.2 * similarity(movies.title, source.title)::numeric
+				)::float8 AS score
+				FROM movies, source
+				WHERE movies.deleted_at IS NULL AND movies.id <> $1 AND %s
+					AND (movies.genres && (SELECT genres FROM source) OR movies.title %% (SELECT title FROM source))
+			) AS candidates
+			ORDER BY score DESC, id ASC
+			LIMIT $2`, strings.Join(allMovieColumns, ", "), vis.where(&args))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(append(movie.scanDest(allMovieColumns), &movie.Score)...)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// The TextSearchConfigs() method returns the names of the installed text search configurations which title searches can use, such as "simple"
+// and "english". Searching in any other configuration would scan the whole movies table, so only the configurations with a title index are
+// returned: simple, which is covered by movies_title_idx, and those with a movies_title_<config>_idx expression index.
+func (m MovieModel) TextSearchConfigs() ([]string, error) {
+	query := `
+			SELECT cfgname FROM pg_ts_config
+			WHERE cfgname = 'simple' OR EXISTS (
+				SELECT 1 FROM pg_indexes WHERE tablename = 'movies' AND indexname = 'movies_title_' || cfgname || '_idx'
+			)
+			ORDER BY cfgname`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	configs := []string{}
+
+	for rows.Next() {
+		var config string
+
+		err := rows.Scan(&config)
+		if err != nil {
+			return nil, err
+		}
+
+		configs = append(configs, config)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return configs, nil
 }
@@ -0,0 +1,130 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// PosterWidths are the widths, in pixels, of the thumbnails generated for every poster.
+var PosterWidths = []int{92, 185, 342}
+
+// The Poster struct holds the URLs of a movie's poster, and of its thumbnails keyed by size (for example "w185").
+type Poster struct {
+	URL        string            `json:"url"`
+	Thumbnails map[string]string `json:"thumbnails"`
+}
+
+// Posters are stored under a directory named after the SHA-256 hash of the uploaded file, so a key never refers to different images
+// and the files can be cached forever. The directory holds the original file (for example "<hash>/original.png") and one thumbnail for
+// each of the PosterWidths (for example "<hash>/w185.png"). Only the key of the original is stored in the movies table.
+
+// The PosterKey() function returns the key of an original poster with the given hash and format ("jpeg", "png" or "gif").
+func PosterKey(hash, format string) string {
+	return fmt.Sprintf("%s/original.%s", hash, format)
+}
+
+// The ThumbnailKey() function returns the key of the thumbnail of the given width for a poster. Thumbnails are JPEG images if the
+// original is a JPEG, or PNG images otherwise, so that any transparency is kept.
+func ThumbnailKey(posterKey string, width int) string {
+	format := "png"
+	if path.Ext(posterKey) == ".jpeg" {
+		format = "jpeg"
+	}
+
+	return fmt.Sprintf("%s/w%d.%s", path.Dir(posterKey), width, format)
+}
+
+// The newPoster() function returns the Poster for a key, or nil if the key is empty (because the movie has no poster).
+func newPoster(key string) *Poster {
+	if key == "" {
+		return nil
+	}
+
+	poster := &Poster{
+		URL:        "/v1/posters/" + key,
+		Thumbnails: make(map[string]string, len(PosterWidths)),
+	}
+
+	for _, width := range PosterWidths {
+		poster.Thumbnails[fmt.Sprintf("w%d", width)] = "/v1/posters/" + ThumbnailKey(key, width)
+	}
+
+	return poster
+}
+
+// The posterDest type is used as the rows.Scan() destination for the poster column, converting the stored key into a Poster.
+type posterDest struct {
+	poster **Poster
+}
+
+func (d posterDest) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*d.poster = nil
+	case string:
+		*d.poster = newPoster(src)
+	case []byte:
+		*d.poster = newPoster(string(src))
+	default:
+		return fmt.Errorf("cannot scan %T into a poster", src)
+	}
+
+	return nil
+}
+
+// The SetPoster() method sets the key of a movie's poster. Like Update(), it uses the version number for optimistic locking, and the new
+// version of the movie is recorded in movie_revisions against userID.
+func (m MovieModel) SetPoster(movie *Movie, key string, userID int64) error {
+	query := `
+			WITH movie AS (
+				UPDATE movies
+				SET poster = $1, updated_at = NOW(), updated_by = $4, version = version + 1
+				WHERE id = $2 AND version = $3 AND deleted_at IS NULL
+				RETURNING id, title, year, runtime, genres, imdb_id, tmdb_id, version, updated_at, updated_by
+			), revision AS (
+				INSERT INTO movie_revisions (movie_id, version, title, year, runtime, genres, imdb_id, tmdb_id, user_id)
+				SELECT id, version, title, year, runtime, genres, imdb_id, tmdb_id, $4 FROM movie
+			)
+			SELECT version, updated_at, updated_by FROM movie`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, key, movie.ID, movie.Version, userID).Scan(&movie.Version, &movie.UpdatedAt, &movie.UpdatedBy)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	movie.Poster = newPoster(key)
+
+	return nil
+}
+
+// The PosterInUse() method reports whether any movie (including those in the trash) uses the poster with the given key. The same image can
+// be uploaded for more than one movie, so this is checked before the files of a replaced poster are deleted.
+func (m MovieModel) PosterInUse(key string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM movies WHERE poster = $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(&exists)
+	return exists, err
+}
+
+// The PosterKeyFromURL() function returns the key of a poster from its URL, which is how handlers find the key of a movie's current poster.
+func PosterKeyFromURL(url string) string {
+	return strings.TrimPrefix(url, "/v1/posters/")
+}
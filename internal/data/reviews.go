@@ -0,0 +1,256 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// Define a custom ErrDuplicateReview error, which is returned when a user tries to review the same movie twice.
+var ErrDuplicateReview = errors.New("duplicate review")
+
+// The Review struct holds a single user's rating (from 1 to 10) and review of a movie.
+type Review struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"`
+	Rating    int32     `json:"rating"`
+	Body      string    `json:"body"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating != 0, "rating", "must be provided")
+	v.Check(review.Rating >= 1 && review.Rating <= 10, "rating", "must be between 1 and 10")
+
+	v.Check(len(review.Body) <= 10_000, "body", "must not be more than 10000 bytes long")
+}
+
+// Define a ReviewModel struct type which wraps a sql.DB connection pool.
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+// The Insert() method adds a new review and updates the rating aggregates of the movie in the same transaction.
+func (m ReviewModel) Insert(review *Review) error {
+	query := `
+			INSERT INTO reviews (movie_id, user_id, rating, body)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, created_at, updated_at, version`
+
+	return m.withMovieRatings(review.MovieID, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, review.MovieID, review.UserID, review.Rating, review.Body).Scan(
+			&review.ID,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.Version,
+		)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "reviews_movie_id_user_id_key"`:
+				return ErrDuplicateReview
+			default:
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// The Get() method returns a specific review of a movie.
+func (m ReviewModel) Get(movieID, id int64) (*Review, error) {
+	if movieID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+			SELECT id, created_at, updated_at, movie_id, user_id, rating, body, version
+			FROM reviews
+			WHERE movie_id = $1 AND id = $2`
+
+	var review Review
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, id).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.MovieID,
+		&review.UserID,
+		&review.Rating,
+		&review.Body,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// The Update() method saves the changes to a review, using the version number for optimistic locking in the same way as MovieModel.Update().
+// If the review has been changed or deleted since it was read, ErrEditConflict is returned.
+func (m ReviewModel) Update(review *Review) error {
+	query := `
+			UPDATE reviews
+			SET rating = $1, body = $2, updated_at = NOW(), version = version + 1
+			WHERE id = $3 AND version = $4
+			RETURNING updated_at, version`
+
+	return m.withMovieRatings(review.MovieID, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, review.Rating, review.Body, review.ID, review.Version).Scan(&review.UpdatedAt, &review.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// The Delete() method removes a review and updates the rating aggregates of the movie.
+func (m ReviewModel) Delete(movieID, id int64) error {
+	if movieID < 1 || id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM reviews WHERE movie_id = $1 AND id = $2`
+
+	return m.withMovieRatings(movieID, func(ctx context.Context, tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, query, movieID, id)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+
+		return nil
+	})
+}
+
+// The GetAllForMovie() method returns a page of the reviews for a specific movie.
+func (m ReviewModel) GetAllForMovie(movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+			SELECT count(*) OVER(), id, created_at, updated_at, movie_id, user_id, rating, body, version
+			FROM reviews
+			WHERE movie_id = $1
+			ORDER BY %s %s, id ASC
+			LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.Rating,
+			&review.Body,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
+
+// The withMovieRatings() method runs fn in a transaction and then recalculates the average_rating and ratings_count columns of the movie
+// before committing, so that the aggregates always agree with the reviews. The movie row is locked first, which makes concurrent changes to
+// the reviews of the same movie take turns; otherwise two transactions could each recalculate the aggregates without seeing the other's review.
+func (m ReviewModel) withMovieRatings(movieID int64, fn func(context.Context, *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+
+	err = tx.QueryRowContext(ctx, `SELECT id FROM movies WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, movieID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = fn(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	err = updateMovieRatings(ctx, tx, movieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The updateMovieRatings() function recalculates the rating aggregates of a movie from its reviews. The aggregates aren't part of the editable
+// movie data, so the version number is left alone, but updated_at is changed so that conditional GET requests see the new values.
+func updateMovieRatings(ctx context.Context, tx *sql.Tx, movieID int64) error {
+	query := `
+			UPDATE movies
+			SET ratings_count = stats.count, average_rating = stats.average, updated_at = NOW()
+			FROM (SELECT count(*) AS count, COALESCE(avg(rating), 0) AS average FROM reviews WHERE movie_id = $1) AS stats
+			WHERE movies.id = $1`
+
+	_, err := tx.ExecContext(ctx, query, movieID)
+	return err
+}
@@ -0,0 +1,172 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The MovieRevision struct holds a copy of a movie as it was at a particular version, along with the user who made the change.
+type MovieRevision struct {
+	MovieID   int64     `json:"movie_id"`
+	Version   int32     `json:"version"`
+	Title     string    `json:"title"`
+	Year      int32     `json:"year"`
+	Runtime   Runtime   `json:"runtime"`
+	Genres    []string  `json:"genres"`
+	IMDbID    string    `json:"imdb_id,omitempty"`
+	TMDbID    int64     `json:"tmdb_id,omitempty"`
+	UserID    *int64    `json:"user_id"` // The acting user. This is null for revisions which pre-date revision tracking, or if the user has been deleted.
+	CreatedAt time.Time `json:"created_at"`
+	// The ID of the movie which the revision originally belonged to, if it came from a movie that was merged into this one.
+	MergedFrom *int64 `json:"merged_from,omitempty"`
+}
+
+// The FieldChange struct describes the change to a single field between two revisions.
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// The DiffRevisions() function returns the field-level changes needed to get from one revision of a movie to another.
+func DiffRevisions(from, to *MovieRevision) []FieldChange {
+	changes := []FieldChange{}
+
+	if from.Title != to.Title {
+		changes = append(changes, FieldChange{Field: "title", From: from.Title, To: to.Title})
+	}
+	if from.Year != to.Year {
+		changes = append(changes, FieldChange{Field: "year", From: from.Year, To: to.Year})
+	}
+	if from.Runtime != to.Runtime {
+		changes = append(changes, FieldChange{Field: "runtime", From: from.Runtime, To: to.Runtime})
+	}
+	if !equalStrings(from.Genres, to.Genres) {
+		changes = append(changes, FieldChange{Field: "genres", From: from.Genres, To: to.Genres})
+	}
+	if from.IMDbID != to.IMDbID {
+		changes = append(changes, FieldChange{Field: "imdb_id", From: from.IMDbID, To: to.IMDbID})
+	}
+	if from.TMDbID != to.TMDbID {
+		changes = append(changes, FieldChange{Field: "tmdb_id", From: from.TMDbID, To: to.TMDbID})
+	}
+
+	return changes
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Define a MovieRevisionModel struct type which wraps a sql.DB connection pool.
+type MovieRevisionModel struct {
+	DB *sql.DB
+}
+
+// The Get() method returns a specific version of a movie. Revisions which came from merged movies have their own version numbers, so they
+// can't be fetched this way.
+func (m MovieRevisionModel) Get(movieID int64, version int32) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+			SELECT movie_id, version, title, year, runtime, genres, imdb_id, tmdb_id, user_id, created_at
+			FROM movie_revisions
+			WHERE movie_id = $1 AND version = $2 AND merged_from IS NULL`
+
+	var revision MovieRevision
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(
+		&revision.MovieID,
+		&revision.Version,
+		&revision.Title,
+		&revision.Year,
+		&revision.Runtime,
+		pq.Array(&revision.Genres),
+		&revision.IMDbID,
+		&revision.TMDbID,
+		&revision.UserID,
+		&revision.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
+
+// The GetAllForMovie() method returns a page of the revisions for a specific movie, including those of any movies merged into it.
+func (m MovieRevisionModel) GetAllForMovie(movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	query := fmt.Sprintf(`
+			SELECT count(*) OVER(), movie_id, version, title, year, runtime, genres, imdb_id, tmdb_id, user_id, created_at, merged_from
+			FROM movie_revisions
+			WHERE movie_id = $1
+			ORDER BY %s %s
+			LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		var revision MovieRevision
+
+		err := rows.Scan(
+			&totalRecords,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.Title,
+			&revision.Year,
+			&revision.Runtime,
+			pq.Array(&revision.Genres),
+			&revision.IMDbID,
+			&revision.TMDbID,
+			&revision.UserID,
+			&revision.CreatedAt,
+			&revision.MergedFrom,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
@@ -0,0 +1,72 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffRevisions(t *testing.T) {
+	base := MovieRevision{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}}
+
+	tests := []struct {
+		name string
+		edit func(*MovieRevision)
+		want []FieldChange
+	}{
+		{
+			name: "no changes",
+			edit: func(*MovieRevision) {},
+			want: []FieldChange{},
+		},
+		{
+			name: "title",
+			edit: func(r *MovieRevision) { r.Title = "Vaiana" },
+			want: []FieldChange{{Field: "title", From: "Moana", To: "Vaiana"}},
+		},
+		{
+			name: "year and runtime",
+			edit: func(r *MovieRevision) { r.Year, r.Runtime = 2017, 110 },
+			want: []FieldChange{
+				{Field: "year", From: int32(2016), To: int32(2017)},
+				{Field: "runtime", From: Runtime(107), To: Runtime(110)},
+			},
+		},
+		{
+			name: "genre added",
+			edit: func(r *MovieRevision) { r.Genres = []string{"animation", "adventure", "comedy"} },
+			want: []FieldChange{{Field: "genres", From: []string{"animation", "adventure"}, To: []string{"animation", "adventure", "comedy"}}},
+		},
+		{
+			name: "genres reordered",
+			edit: func(r *MovieRevision) { r.Genres = []string{"adventure", "animation"} },
+			want: []FieldChange{{Field: "genres", From: []string{"animation", "adventure"}, To: []string{"adventure", "animation"}}},
+		},
+		{
+			name: "external IDs",
+			edit: func(r *MovieRevision) { r.IMDbID, r.TMDbID = "tt3521164", 277834 },
+			want: []FieldChange{
+				{Field: "imdb_id", From: "", To: "tt3521164"},
+				{Field: "tmdb_id", From: int64(0), To: int64(277834)},
+			},
+		},
+		{
+			name: "fields other than the movie data are ignored",
+			edit: func(r *MovieRevision) { r.Version, r.MovieID = 2, 3 },
+			want: []FieldChange{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from := base
+			to := base
+			to.Genres = append([]string(nil), base.Genres...)
+			tt.edit(&to)
+
+			got := DiffRevisions(&from, &to)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -13,14 +13,17 @@ var ErrInvalidRuntimeFormat = errors.New("invalid runtime format")
 // Declare a custom Runtime type, which has the underlying type int32.
 type Runtime int32
 
+// The String() method returns the runtime in the format "<runtime> mins".
+func (r Runtime) String() string {
+	return fmt.Sprintf("%d mins", r)
+}
+
 // Implement a MarshalJSON() method on the Runtime type so that it satisfies the json.Marshaler interface.
 // This should return the JSON-encoded value for the movie runtime (it will return a string in the format "<runtime> mins").
 func (r Runtime) MarshalJSON() ([]byte, error) {
-	// Generate a string containing the movie runtime in the required format.
-	jsonValue := fmt.Sprintf("%d mins", r)
 	// Use the strconv.Quote() function on the string to wrap it in double quotes.
 	// It needs to be surrounded by double quotes in order to be a valid *JSON string*.
-	quotedJSONValue := strconv.Quote(jsonValue)
+	quotedJSONValue := strconv.Quote(r.String())
 	// Convert the quoted string value to a byte slice and return it.
 	return []byte(quotedJSONValue), nil
 }
@@ -34,22 +37,27 @@ func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
 		return ErrInvalidRuntimeFormat
 	}
 
+	// Parse the unquoted string and assign the result to the receiver.
+	*r, err = ParseRuntime(unquotedJSONValue)
+	return err
+}
+
+// The ParseRuntime() function parses a string in the format "<runtime> mins", returning the ErrInvalidRuntimeFormat error if it isn't in the expected format.
+func ParseRuntime(s string) (Runtime, error) {
 	// Split the string to isolate the part containing the number.
-	parts := strings.Split(unquotedJSONValue, " ")
+	parts := strings.Split(s, " ")
 
 	// Sanity check the parts of the string to make sure it was in the expected format. If it isn't, we return the ErrInvalidRuntimeFormat error again.
 	if len(parts) != 2 || parts[1] != "mins" {
-		return ErrInvalidRuntimeFormat
+		return 0, ErrInvalidRuntimeFormat
 	}
 
 	// Otherwise, parse the string containing the number into an int32. Again, if this fails return the ErrInvalidRuntimeFormat error.
 	i, err := strconv.ParseInt(parts[0], 10, 32)
 	if err != nil {
-		return ErrInvalidRuntimeFormat
+		return 0, ErrInvalidRuntimeFormat
 	}
 
-	// Convert the int32 to a Runtime type and assign this to the receiver.
-	*r = Runtime(i)
-
-	return nil
+	// Convert the int32 to a Runtime type and return it.
+	return Runtime(i), nil
 }
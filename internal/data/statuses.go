@@ -0,0 +1,110 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// The statuses of a movie. New movies start as drafts, and are only visible to everyone once they have been published.
+const (
+	MovieStatusDraft     = "draft"
+	MovieStatusInReview  = "in_review"
+	MovieStatusPublished = "published"
+	MovieStatusArchived  = "archived"
+)
+
+// MovieStatuses lists every status, in workflow order.
+var MovieStatuses = []string{MovieStatusDraft, MovieStatusInReview, MovieStatusPublished, MovieStatusArchived}
+
+// The movieStatusTransitions map holds the statuses which a movie can be moved to from each status. Only movies which have been reviewed
+// can be published, and archived movies have to go back through review before they can be published again.
+var movieStatusTransitions = map[string][]string{
+	MovieStatusDraft:     {MovieStatusInReview, MovieStatusArchived},
+	MovieStatusInReview:  {MovieStatusDraft, MovieStatusPublished, MovieStatusArchived},
+	MovieStatusPublished: {MovieStatusArchived},
+	MovieStatusArchived:  {MovieStatusDraft},
+}
+
+// The Visibility struct describes which movies a user can see. Everyone can see the movies which have been published (and aren't scheduled
+// for the future). Users who can change any movie can see every movie, and users who can only change their own movies can also see the
+// unpublished movies which they created.
+type Visibility struct {
+	All   bool  // every movie is visible
+	Owner int64 // unpublished movies created by the user with this ID are visible
+}
+
+// The condition() method returns the SQL condition for the visible movies, in the same form as the conditions built by MovieFilters.where():
+// the %d verb is replaced with the number of the placeholder for the returned argument. An empty condition means that every movie is visible.
+func (vis Visibility) condition() (string, interface{}) {
+	if vis.All {
+		return "", nil
+	}
+
+	return "(status = 'published' AND (publish_at IS NULL OR publish_at <= NOW()) OR created_by = $%d)", vis.Owner
+}
+
+// The where() method returns the SQL condition for the visible movies as a complete condition, appending its argument to args so that it
+// uses the next placeholder. The condition is simply TRUE if every movie is visible.
+func (vis Visibility) where(args *[]interface{}) string {
+	condition, arg := vis.condition()
+	if condition == "" {
+		return "TRUE"
+	}
+
+	*args = append(*args, arg)
+	return fmt.Sprintf(condition, len(*args))
+}
+
+// The ValidateStatusChange() function checks that a movie can be moved to a new status. A publishAt time can only be given when publishing, and
+// must be in the future; the movie then stays hidden until that time.
+func ValidateStatusChange(v *validator.Validator, movie *Movie, status string, publishAt *time.Time) {
+	v.Check(status != "", "status", "must be provided")
+	v.Check(validator.In(status, MovieStatuses...), "status", "must be one of draft, in_review, published or archived")
+
+	if v.Valid() {
+		v.Check(validator.In(status, movieStatusTransitions[movie.Status]...), "status", fmt.Sprintf("cannot change from %s to %s", movie.Status, status))
+	}
+
+	if publishAt != nil {
+		v.Check(status == MovieStatusPublished, "publish_at", "must only be provided when publishing")
+		v.Check(publishAt.After(time.Now()), "publish_at", "must be in the future")
+	}
+}
+
+// The SetStatus() method saves a movie's status and publishing time. Like Update(), it uses the version number for optimistic locking (which
+// also guarantees that the status hasn't changed since the transition was validated), and the new version is recorded in movie_revisions.
+func (m MovieModel) SetStatus(movie *Movie, userID int64) error {
+	query := `
+			WITH movie AS (
+				UPDATE movies
+				SET status = $1, publish_at = $2, updated_at = NOW(), updated_by = $5, version = version + 1
+				WHERE id = $3 AND version = $4 AND deleted_at IS NULL
+				RETURNING id, title, year, runtime, genres, imdb_id, tmdb_id, version, updated_at, updated_by
+			), revision AS (
+				INSERT INTO movie_revisions (movie_id, version, title, year, runtime, genres, imdb_id, tmdb_id, user_id)
+				SELECT id, version, title, year, runtime, genres, imdb_id, tmdb_id, $5 FROM movie
+			)
+			SELECT version, updated_at, updated_by FROM movie`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{movie.Status, movie.PublishAt, movie.ID, movie.Version, userID}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version, &movie.UpdatedAt, &movie.UpdatedBy)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
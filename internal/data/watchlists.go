@@ -0,0 +1,219 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// Define a custom ErrDuplicateWatchlistEntry error, which is returned when a user adds a movie which is already on their watchlist.
+var ErrDuplicateWatchlistEntry = errors.New("duplicate watchlist entry")
+
+// The WatchlistEntry struct holds a movie on a user's watchlist, along with the title and year of the movie so that clients can show the list
+// without fetching every movie.
+type WatchlistEntry struct {
+	UserID    int64     `json:"-"`
+	MovieID   int64     `json:"movie_id"`
+	Title     string    `json:"title"`
+	Year      int32     `json:"year"`
+	Watched   bool      `json:"watched"`
+	Note      string    `json:"note"`
+	AddedAt   time.Time `json:"added_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func ValidateWatchlistEntry(v *validator.Validator, entry *WatchlistEntry) {
+	v.Check(entry.MovieID > 0, "movie_id", "must be provided")
+	v.Check(len(entry.Note) <= 1000, "note", "must not be more than 1000 bytes long")
+}
+
+// Define a WatchlistModel struct type which wraps a sql.DB connection pool.
+type WatchlistModel struct {
+	DB *sql.DB
+}
+
+// The Insert() method adds a movie to a user's watchlist. Entries are removed automatically (by the foreign key constraints) when the movie or
+// the user is permanently deleted.
+func (m WatchlistModel) Insert(entry *WatchlistEntry) error {
+	query := `
+			INSERT INTO watchlist_entries (user_id, movie_id, watched, note)
+			VALUES ($1, $2, $3, $4)
+			RETURNING added_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, entry.UserID, entry.MovieID, entry.Watched, entry.Note).Scan(&entry.AddedAt, &entry.UpdatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "watchlist_entries_pkey"`:
+			return ErrDuplicateWatchlistEntry
+		case err.Error() == `pq: insert or update on table "watchlist_entries" violates foreign key constraint "watchlist_entries_movie_id_fkey"`:
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The Get() method returns the entry for a specific movie on a user's watchlist. Movies in the trash, and movies which aren't visible to the
+// user, aren't shown.
+func (m WatchlistModel) Get(userID, movieID int64, vis Visibility) (*WatchlistEntry, error) {
+	if movieID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	args := []interface{}{userID, movieID}
+
+	query := fmt.Sprintf(`
+			SELECT watchlist_entries.user_id, watchlist_entries.movie_id, movies.title, movies.year,
+				watchlist_entries.watched, watchlist_entries.note, watchlist_entries.added_at, watchlist_entries.updated_at
+			FROM watchlist_entries
+			INNER JOIN movies ON movies.id = watchlist_entries.movie_id
+			WHERE watchlist_entries.user_id = $1 AND watchlist_entries.movie_id = $2 AND movies.deleted_at IS NULL AND %s`, vis.where(&args))
+
+	var entry WatchlistEntry
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&entry.UserID,
+		&entry.MovieID,
+		&entry.Title,
+		&entry.Year,
+		&entry.Watched,
+		&entry.Note,
+		&entry.AddedAt,
+		&entry.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &entry, nil
+}
+
+// The Update() method saves changes to the watched flag and note of a watchlist entry. A watchlist is only ever changed by its owner,
+// so unlike MovieModel.Update() there is no optimistic locking.
+func (m WatchlistModel) Update(entry *WatchlistEntry) error {
+	query := `
+			UPDATE watchlist_entries
+			SET watched = $1, note = $2, updated_at = NOW()
+			WHERE user_id = $3 AND movie_id = $4
+			RETURNING updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, entry.Watched, entry.Note, entry.UserID, entry.MovieID).Scan(&entry.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The Delete() method removes a movie from a user's watchlist.
+func (m WatchlistModel) Delete(userID, movieID int64) error {
+	if movieID < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM watchlist_entries WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// The GetAllForUser() method returns a page of a user's watchlist. If watched is not nil, only the entries with a matching watched flag
+// are returned. Movies in the trash, and movies which aren't visible to the user (such as a movie which has gone back to being a draft), are left
+// out, but their entries are kept in case the movie comes back.
+func (m WatchlistModel) GetAllForUser(userID int64, watched *bool, vis Visibility, filters Filters) ([]*WatchlistEntry, Metadata, error) {
+	args := []interface{}{userID, watched, filters.limit(), filters.offset()}
+
+	query := fmt.Sprintf(`
+			SELECT count(*) OVER(), watchlist_entries.user_id, watchlist_entries.movie_id, movies.title, movies.year,
+				watchlist_entries.watched, watchlist_entries.note, watchlist_entries.added_at, watchlist_entries.updated_at
+			FROM watchlist_entries
+			INNER JOIN movies ON movies.id = watchlist_entries.movie_id
+			WHERE watchlist_entries.user_id = $1
+			AND movies.deleted_at IS NULL
+			AND (watchlist_entries.watched = $2 OR $2 IS NULL)
+			AND %s
+			ORDER BY %s %s, watchlist_entries.movie_id ASC
+			LIMIT $3 OFFSET $4`, vis.where(&args), filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchlistEntry{}
+
+	for rows.Next() {
+		var entry WatchlistEntry
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.UserID,
+			&entry.MovieID,
+			&entry.Title,
+			&entry.Year,
+			&entry.Watched,
+			&entry.Note,
+			&entry.AddedAt,
+			&entry.UpdatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
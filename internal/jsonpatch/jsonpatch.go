@@ -0,0 +1,353 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Define an Error type for problems with an individual operation in a JSON Patch document. The Index is the (zero-based) position
+// of the operation within the patch.
+type Error struct {
+	Index   int
+	Op      string
+	Path    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("operation %d (%s %s): %s", e.Index, e.Op, e.Path, e.Message)
+}
+
+// Define the errors that can be returned for problems with the patch document as a whole.
+var (
+	ErrInvalidPatch = errors.New("patch must be a JSON array of operations")
+	ErrInvalidMerge = errors.New("merge patch must be valid JSON")
+)
+
+// The operation struct holds a single JSON Patch operation. The Value is kept as raw JSON so that we can tell the difference
+// between a missing value and an explicit null.
+type operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Apply applies a JSON Patch document (RFC 6902) to a JSON document and returns the patched document. The operations are applied
+// in order, and if any of them fails the whole patch fails and an *Error is returned.
+func Apply(doc, patch []byte) ([]byte, error) {
+	var target interface{}
+
+	err := json.Unmarshal(doc, &target)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []operation
+
+	err = json.Unmarshal(patch, &ops)
+	if err != nil {
+		return nil, ErrInvalidPatch
+	}
+
+	for i, op := range ops {
+		target, err = op.apply(target)
+		if err != nil {
+			return nil, &Error{Index: i, Op: op.Op, Path: op.Path, Message: err.Error()}
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+// MergePatch applies a JSON Merge Patch document (RFC 7396) to a JSON document and returns the patched document.
+// Members of the patch with a null value are removed from the target.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var target, changes interface{}
+
+	err := json.Unmarshal(doc, &target)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(patch, &changes)
+	if err != nil {
+		return nil, ErrInvalidMerge
+	}
+
+	return json.Marshal(mergePatch(target, changes))
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	changes, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	object, ok := target.(map[string]interface{})
+	if !ok {
+		object = make(map[string]interface{})
+	}
+
+	for key, value := range changes {
+		if value == nil {
+			delete(object, key)
+			continue
+		}
+		object[key] = mergePatch(object[key], value)
+	}
+
+	return object
+}
+
+// The apply() method applies a single operation to the document, returning the updated document.
+func (op operation) apply(doc interface{}) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, errors.New("value must be provided")
+		}
+
+		var value interface{}
+		err := json.Unmarshal(op.Value, &value)
+		if err != nil {
+			return nil, errors.New("value must be valid JSON")
+		}
+
+		switch op.Op {
+		case "add":
+			return add(doc, path, value)
+		case "replace":
+			doc, _, err = remove(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			return add(doc, path, value)
+		default:
+			current, err := get(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(current, value) {
+				return nil, errors.New("test failed")
+			}
+			return doc, nil
+		}
+
+	case "remove":
+		doc, _, err = remove(doc, path)
+		return doc, err
+
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+
+		var value interface{}
+
+		if op.Op == "move" {
+			// A location can't be moved into one of its own children.
+			if len(from) < len(path) && reflect.DeepEqual(from, path[:len(from)]) {
+				return nil, errors.New("from must not be a parent of path")
+			}
+			doc, value, err = remove(doc, from)
+		} else {
+			value, err = get(doc, from)
+			if err == nil {
+				value, err = deepCopy(value)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+
+		return add(doc, path, value)
+
+	default:
+		return nil, errors.New("op must be one of add, remove, replace, move, copy or test")
+	}
+}
+
+// The parsePointer() function splits a JSON Pointer (RFC 6901) into its unescaped reference tokens.
+// The empty string refers to the whole document.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.New("path must be empty or start with a /")
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// The arrayIndex() function converts a reference token to an index into an array of the given length. If allowEnd is true,
+// the index may be equal to the length (as it is for "-", which refers to the position after the last element).
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+
+	// Leading zeros (and signs) aren't allowed by RFC 6901.
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.ContainsAny(token, "+-") {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+
+	if i >= length && !(allowEnd && i == length) {
+		return 0, fmt.Errorf("array index %d is out of range", i)
+	}
+
+	return i, nil
+}
+
+// The get() function returns the value at the given path.
+func get(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			doc = value
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("cannot refer to %q within a scalar value", token)
+		}
+	}
+
+	return doc, nil
+}
+
+// The add() function adds a value at the given path, returning the updated document. Object members are created or replaced,
+// and values are inserted into arrays at the given index.
+func add(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[token] = value
+		return doc, nil
+	case []interface{}:
+		i, err := arrayIndex(token, len(node), true)
+		if err != nil {
+			return nil, err
+		}
+
+		node = append(node, nil)
+		copy(node[i+1:], node[i:])
+		node[i] = value
+
+		// Appending may have reallocated the array, so it needs to be put back into its parent.
+		return replaceValue(doc, path[:len(path)-1], node)
+	default:
+		return nil, fmt.Errorf("cannot add %q to a scalar value", token)
+	}
+}
+
+// The remove() function removes the value at the given path, returning the updated document and the removed value.
+func remove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	token := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		value, ok := node[token]
+		if !ok {
+			return nil, nil, fmt.Errorf("member %q does not exist", token)
+		}
+		delete(node, token)
+		return doc, value, nil
+	case []interface{}:
+		i, err := arrayIndex(token, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		value := node[i]
+		node = append(node[:i:i], node[i+1:]...)
+
+		doc, err = replaceValue(doc, path[:len(path)-1], node)
+		return doc, value, err
+	default:
+		return nil, nil, fmt.Errorf("cannot remove %q from a scalar value", token)
+	}
+}
+
+// The replaceValue() function sets the value at a path which is known to exist, returning the updated document.
+func replaceValue(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[token] = value
+	case []interface{}:
+		i, err := arrayIndex(token, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = value
+	}
+
+	return doc, nil
+}
+
+// The deepCopy() function returns a copy of a decoded JSON value which doesn't share any maps or slices with the original.
+func deepCopy(value interface{}) (interface{}, error) {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var clone interface{}
+	err = json.Unmarshal(js, &clone)
+	return clone, err
+}
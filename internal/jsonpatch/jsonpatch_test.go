@@ -0,0 +1,333 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// The normalize() helper re-encodes a JSON document, so that documents can be compared regardless of whitespace and the order of members.
+func normalize(t *testing.T, doc string) string {
+	t.Helper()
+
+	var value interface{}
+
+	err := json.Unmarshal([]byte(doc), &value)
+	if err != nil {
+		t.Fatalf("invalid JSON %q: %v", doc, err)
+	}
+
+	js, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(js)
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string // empty if the patch should fail
+	}{
+		{
+			name:  "add member",
+			doc:   `{"title":"Moana"}`,
+			patch: `[{"op":"add","path":"/year","value":2016}]`,
+			want:  `{"title":"Moana","year":2016}`,
+		},
+		{
+			name:  "add replaces existing member",
+			doc:   `{"year":2015}`,
+			patch: `[{"op":"add","path":"/year","value":2016}]`,
+			want:  `{"year":2016}`,
+		},
+		{
+			name:  "add null value",
+			doc:   `{}`,
+			patch: `[{"op":"add","path":"/poster","value":null}]`,
+			want:  `{"poster":null}`,
+		},
+		{
+			name:  "add without value",
+			doc:   `{}`,
+			patch: `[{"op":"add","path":"/year"}]`,
+		},
+		{
+			name:  "add to missing parent",
+			doc:   `{}`,
+			patch: `[{"op":"add","path":"/a/b","value":1}]`,
+		},
+		{
+			name:  "add array index",
+			doc:   `{"genres":["drama","comedy"]}`,
+			patch: `[{"op":"add","path":"/genres/1","value":"action"}]`,
+			want:  `{"genres":["drama","action","comedy"]}`,
+		},
+		{
+			name:  "add array end",
+			doc:   `{"genres":["drama"]}`,
+			patch: `[{"op":"add","path":"/genres/-","value":"action"}]`,
+			want:  `{"genres":["drama","action"]}`,
+		},
+		{
+			name:  "add array index equal to length",
+			doc:   `{"genres":["drama"]}`,
+			patch: `[{"op":"add","path":"/genres/1","value":"action"}]`,
+			want:  `{"genres":["drama","action"]}`,
+		},
+		{
+			name:  "add array index out of range",
+			doc:   `{"genres":["drama"]}`,
+			patch: `[{"op":"add","path":"/genres/2","value":"action"}]`,
+		},
+		{
+			name:  "add array index with leading zero",
+			doc:   `{"genres":["drama","comedy"]}`,
+			patch: `[{"op":"add","path":"/genres/01","value":"action"}]`,
+		},
+		{
+			name:  "add whole document",
+			doc:   `{"title":"Moana"}`,
+			patch: `[{"op":"add","path":"","value":[1,2]}]`,
+			want:  `[1,2]`,
+		},
+		{
+			name:  "remove member",
+			doc:   `{"title":"Moana","year":2016}`,
+			patch: `[{"op":"remove","path":"/year"}]`,
+			want:  `{"title":"Moana"}`,
+		},
+		{
+			name:  "remove missing member",
+			doc:   `{"title":"Moana"}`,
+			patch: `[{"op":"remove","path":"/year"}]`,
+		},
+		{
+			name:  "remove array element",
+			doc:   `{"genres":["drama","action","comedy"]}`,
+			patch: `[{"op":"remove","path":"/genres/1"}]`,
+			want:  `{"genres":["drama","comedy"]}`,
+		},
+		{
+			name:  "remove array end",
+			doc:   `{"genres":["drama"]}`,
+			patch: `[{"op":"remove","path":"/genres/-"}]`,
+		},
+		{
+			name:  "replace member",
+			doc:   `{"title":"Moana","year":2015}`,
+			patch: `[{"op":"replace","path":"/year","value":2016}]`,
+			want:  `{"title":"Moana","year":2016}`,
+		},
+		{
+			name:  "replace missing member",
+			doc:   `{"title":"Moana"}`,
+			patch: `[{"op":"replace","path":"/year","value":2016}]`,
+		},
+		{
+			name:  "replace array element",
+			doc:   `{"genres":["drama","comedy"]}`,
+			patch: `[{"op":"replace","path":"/genres/0","value":"action"}]`,
+			want:  `{"genres":["action","comedy"]}`,
+		},
+		{
+			name:  "move member",
+			doc:   `{"a":{"b":1},"c":{}}`,
+			patch: `[{"op":"move","from":"/a/b","path":"/c/d"}]`,
+			want:  `{"a":{},"c":{"d":1}}`,
+		},
+		{
+			name:  "move array element",
+			doc:   `{"genres":["drama","action","comedy"]}`,
+			patch: `[{"op":"move","from":"/genres/0","path":"/genres/-"}]`,
+			want:  `{"genres":["action","comedy","drama"]}`,
+		},
+		{
+			name:  "move into own child",
+			doc:   `{"a":{"b":{}}}`,
+			patch: `[{"op":"move","from":"/a","path":"/a/b/c"}]`,
+		},
+		{
+			name:  "move to sibling with common prefix",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"move","from":"/a","path":"/ab"}]`,
+			want:  `{"ab":1}`,
+		},
+		{
+			name:  "move missing member",
+			doc:   `{}`,
+			patch: `[{"op":"move","from":"/a","path":"/b"}]`,
+		},
+		{
+			name:  "copy member",
+			doc:   `{"a":{"b":[1]}}`,
+			patch: `[{"op":"copy","from":"/a","path":"/c"},{"op":"add","path":"/c/b/-","value":2}]`,
+			want:  `{"a":{"b":[1]},"c":{"b":[1,2]}}`,
+		},
+		{
+			name:  "copy array element",
+			doc:   `{"genres":["drama","comedy"]}`,
+			patch: `[{"op":"copy","from":"/genres/1","path":"/genres/0"}]`,
+			want:  `{"genres":["comedy","drama","comedy"]}`,
+		},
+		{
+			name:  "test passes",
+			doc:   `{"title":"Moana","genres":["drama"]}`,
+			patch: `[{"op":"test","path":"/genres","value":["drama"]},{"op":"replace","path":"/title","value":"Up"}]`,
+			want:  `{"title":"Up","genres":["drama"]}`,
+		},
+		{
+			name:  "test fails",
+			doc:   `{"title":"Moana"}`,
+			patch: `[{"op":"test","path":"/title","value":"Up"}]`,
+		},
+		{
+			name:  "test fails on type",
+			doc:   `{"year":2016}`,
+			patch: `[{"op":"test","path":"/year","value":"2016"}]`,
+		},
+		{
+			name:  "escaped tilde and slash",
+			doc:   `{"a/b":1,"m~n":2}`,
+			patch: `[{"op":"replace","path":"/a~1b","value":3},{"op":"remove","path":"/m~0n"}]`,
+			want:  `{"a/b":3}`,
+		},
+		{
+			name:  "escapes are decoded in order",
+			doc:   `{"~1":1}`,
+			patch: `[{"op":"remove","path":"/~01"}]`,
+			want:  `{}`,
+		},
+		{
+			name:  "path without leading slash",
+			doc:   `{"title":"Moana"}`,
+			patch: `[{"op":"remove","path":"title"}]`,
+		},
+		{
+			name:  "unknown op",
+			doc:   `{"title":"Moana"}`,
+			patch: `[{"op":"delete","path":"/title"}]`,
+		},
+		{
+			name:  "failed operation leaves nothing applied",
+			doc:   `{"title":"Moana"}`,
+			patch: `[{"op":"add","path":"/year","value":2016},{"op":"test","path":"/title","value":"Up"}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(tt.doc), []byte(tt.patch))
+
+			if tt.want == "" {
+				var patchErr *Error
+				if !errors.As(err, &patchErr) {
+					t.Fatalf("got %s, %v; want an *Error", got, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(got) != normalize(t, tt.want) {
+				t.Errorf("got %s; want %s", got, normalize(t, tt.want))
+			}
+		})
+	}
+}
+
+func TestApplyErrorIndex(t *testing.T) {
+	_, err := Apply([]byte(`{"title":"Moana"}`), []byte(`[{"op":"test","path":"/title","value":"Moana"},{"op":"test","path":"/title","value":"Up"}]`))
+
+	var patchErr *Error
+	if !errors.As(err, &patchErr) {
+		t.Fatalf("got %v; want an *Error", err)
+	}
+	if patchErr.Index != 1 || patchErr.Op != "test" || patchErr.Path != "/title" {
+		t.Errorf("got %+v; want the second operation", patchErr)
+	}
+}
+
+func TestApplyInvalidPatch(t *testing.T) {
+	for _, patch := range []string{`{"op":"add"}`, `not json`} {
+		_, err := Apply([]byte(`{}`), []byte(patch))
+		if !errors.Is(err, ErrInvalidPatch) {
+			t.Errorf("patch %s: got %v; want ErrInvalidPatch", patch, err)
+		}
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{
+			name:  "replace member",
+			doc:   `{"title":"Moana","year":2015}`,
+			patch: `{"year":2016}`,
+			want:  `{"title":"Moana","year":2016}`,
+		},
+		{
+			name:  "null deletes member",
+			doc:   `{"title":"Moana","year":2016}`,
+			patch: `{"year":null}`,
+			want:  `{"title":"Moana"}`,
+		},
+		{
+			name:  "null for missing member",
+			doc:   `{"title":"Moana"}`,
+			patch: `{"year":null}`,
+			want:  `{"title":"Moana"}`,
+		},
+		{
+			name:  "nested objects are merged",
+			doc:   `{"a":{"b":1,"c":2}}`,
+			patch: `{"a":{"b":null,"d":3}}`,
+			want:  `{"a":{"c":2,"d":3}}`,
+		},
+		{
+			name:  "arrays are replaced",
+			doc:   `{"genres":["drama","comedy"]}`,
+			patch: `{"genres":["action"]}`,
+			want:  `{"genres":["action"]}`,
+		},
+		{
+			name:  "object replaces scalar",
+			doc:   `{"a":1}`,
+			patch: `{"a":{"b":null,"c":2}}`,
+			want:  `{"a":{"c":2}}`,
+		},
+		{
+			name:  "non-object patch replaces document",
+			doc:   `{"a":1}`,
+			patch: `[1]`,
+			want:  `[1]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(got) != normalize(t, tt.want) {
+				t.Errorf("got %s; want %s", got, normalize(t, tt.want))
+			}
+		})
+	}
+}
+
+func TestMergePatchInvalid(t *testing.T) {
+	_, err := MergePatch([]byte(`{}`), []byte(`{`))
+	if !errors.Is(err, ErrInvalidMerge) {
+		t.Errorf("got %v; want ErrInvalidMerge", err)
+	}
+}
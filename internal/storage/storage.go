@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Define a custom ErrNotFound error, which is returned by Get() and Delete() when there is no file with the given key.
+var ErrNotFound = errors.New("storage: file not found")
+
+// The Storage interface is implemented by the backends which uploaded files are kept in. Files are identified by a key, which is a
+// slash-separated relative path such as "posters/abc/original.png".
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Local stores files in a directory on the local filesystem.
+type Local struct {
+	dir string
+}
+
+// The NewLocal() function returns a Local storage backend for the given directory, creating the directory if it doesn't exist.
+func NewLocal(dir string) (*Local, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Local{dir: dir}, nil
+}
+
+// The Put() method writes a file, replacing any existing file with the same key. The data is written to a temporary file first, which is then
+// renamed, so readers never see a partially written file.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+// The Get() method opens a file for reading. The caller must close it.
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// The Delete() method removes a file.
+func (l *Local) Delete(ctx context.Context, key string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// The path() method converts a key into a path inside the storage directory. Keys which are absolute, or which would resolve to somewhere
+// outside the directory, are rejected.
+func (l *Local) path(key string) (string, error) {
+	clean := path.Clean(key)
+	if key == "" || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", errors.New("storage: invalid key")
+	}
+
+	return filepath.Join(l.dir, filepath.FromSlash(clean)), nil
+}
@@ -0,0 +1,71 @@
+package thumbnail
+
+import (
+	"image"
+	"image/draw"
+)
+
+// The RGBA() function converts an image to RGBA, so that its pixels can be read directly by Resize() rather than through the image.Image
+// interface. Converting a large image allocates a lot of memory, so when making several thumbnails of the same image it should only be done
+// once. Images which are already RGBA (with their bounds at the origin) are returned unchanged.
+func RGBA(src image.Image) *image.RGBA {
+	if rgba, ok := src.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+
+	bounds := src.Bounds()
+
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	return rgba
+}
+
+// The Resize() function scales an image down to the given width, keeping its aspect ratio. Each pixel of the result is the average of the
+// block of source pixels it covers (a box filter), which gives good results when shrinking. Images which are already no wider than width are
+// returned at their original size, as enlarging them wouldn't add any detail. The source image isn't modified.
+func Resize(src *image.RGBA, width int) *image.RGBA {
+	src = RGBA(src)
+
+	srcW, srcH := src.Bounds().Dx(), src.Bounds().Dy()
+	if width >= srcW {
+		return src
+	}
+
+	height := srcH * width / srcW
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		// The rows of source pixels covered by this row of the result. Every source row is covered by exactly one row of the result.
+		y0, y1 := y*srcH/height, (y+1)*srcH/height
+
+		for x := 0; x < width; x++ {
+			x0, x1 := x*srcW/width, (x+1)*srcW/width
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(src.Pix[i])
+					g += int(src.Pix[i+1])
+					b += int(src.Pix[i+2])
+					a += int(src.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,95 @@
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestResizeDimensions(t *testing.T) {
+	tests := []struct {
+		srcW, srcH int
+		width      int
+		wantW      int
+		wantH      int
+	}{
+		{600, 900, 92, 92, 138},
+		{600, 900, 300, 300, 450},
+		{1000, 10, 92, 92, 1},
+		{1000, 1, 92, 92, 1},
+		{100, 150, 185, 100, 150},
+		{100, 150, 100, 100, 150},
+	}
+
+	for _, tt := range tests {
+		src := image.NewRGBA(image.Rect(0, 0, tt.srcW, tt.srcH))
+
+		dst := Resize(src, tt.width)
+		if got := dst.Bounds(); got != image.Rect(0, 0, tt.wantW, tt.wantH) {
+			t.Errorf("%dx%d to width %d: got %v; want %dx%d", tt.srcW, tt.srcH, tt.width, got, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestResizeAveragesBlocks(t *testing.T) {
+	// A 4x2 image with a black and white checkerboard on the left and solid red on the right.
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	src.Set(0, 0, color.RGBA{0, 0, 0, 255})
+	src.Set(1, 0, color.RGBA{255, 255, 255, 255})
+	src.Set(0, 1, color.RGBA{255, 255, 255, 255})
+	src.Set(1, 1, color.RGBA{0, 0, 0, 255})
+	for y := 0; y < 2; y++ {
+		for x := 2; x < 4; x++ {
+			src.Set(x, y, color.RGBA{255, 0, 0, 255})
+		}
+	}
+
+	dst := Resize(src, 2)
+
+	if got, want := dst.RGBAAt(0, 0), (color.RGBA{127, 127, 127, 255}); got != want {
+		t.Errorf("left pixel: got %v; want %v", got, want)
+	}
+	if got, want := dst.RGBAAt(1, 0), (color.RGBA{255, 0, 0, 255}); got != want {
+		t.Errorf("right pixel: got %v; want %v", got, want)
+	}
+}
+
+func TestResizeDoesNotModifySource(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	src.Set(3, 3, color.RGBA{255, 255, 255, 255})
+	before := append([]uint8(nil), src.Pix...)
+
+	Resize(src, 5)
+
+	for i := range before {
+		if src.Pix[i] != before[i] {
+			t.Fatal("source image was modified")
+		}
+	}
+}
+
+func TestRGBA(t *testing.T) {
+	// Images which are already RGBA at the origin are used as they are.
+	rgba := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	if RGBA(rgba) != rgba {
+		t.Error("RGBA image was copied")
+	}
+
+	// Other images are converted, with their bounds moved to the origin.
+	gray := image.NewGray(image.Rect(2, 3, 6, 5))
+	gray.SetGray(2, 3, color.Gray{200})
+
+	got := RGBA(gray)
+	if got.Bounds() != image.Rect(0, 0, 4, 2) {
+		t.Errorf("got bounds %v; want (0,0)-(4,2)", got.Bounds())
+	}
+	if c := got.RGBAAt(0, 0); c != (color.RGBA{200, 200, 200, 255}) {
+		t.Errorf("got %v at the origin; want the top-left pixel of the source", c)
+	}
+
+	// So are RGBA sub-images which don't start at the origin.
+	sub := rgba.SubImage(image.Rect(1, 1, 3, 3)).(*image.RGBA)
+	if got := RGBA(sub); got.Bounds() != image.Rect(0, 0, 2, 2) {
+		t.Errorf("got bounds %v for sub-image; want (0,0)-(2,2)", got.Bounds())
+	}
+}
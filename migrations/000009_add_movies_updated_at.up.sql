@@ -0,0 +1,4 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+
+-- Existing movies haven't been tracked, so the best we can do is assume they were last modified when they were created.
+UPDATE movies SET updated_at = created_at;
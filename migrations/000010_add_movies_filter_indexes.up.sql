@@ -0,0 +1,4 @@
+-- Indexes for the year, runtime and created_at range filters. Every listing query excludes the trash, so the indexes are partial.
+CREATE INDEX IF NOT EXISTS movies_year_idx ON movies (year) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS movies_runtime_idx ON movies (runtime) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS movies_created_at_idx ON movies (created_at) WHERE deleted_at IS NULL;
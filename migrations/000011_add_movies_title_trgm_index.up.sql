@@ -0,0 +1,3 @@
+-- The pg_trgm extension provides the trigram similarity operators used by fuzzy title searches and the autocomplete endpoint.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS movies_title_trgm_idx ON movies USING GIN (title gin_trgm_ops);
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS movies_search_vector_trigger ON movies;
+DROP FUNCTION IF EXISTS movies_search_vector_update();
+ALTER TABLE movies DROP COLUMN IF EXISTS search_vector;
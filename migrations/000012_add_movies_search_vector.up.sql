@@ -0,0 +1,21 @@
+-- A stored, weighted tsvector for ranking search results. Matches in the title (weight A) rank above matches in the genres (weight B).
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS search_vector tsvector;
+
+CREATE OR REPLACE FUNCTION movies_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector('simple', NEW.title), 'A') ||
+        setweight(to_tsvector('simple', array_to_string(NEW.genres, ' ')), 'B');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+-- Keep the column up to date whenever a movie is created or its title or genres change.
+CREATE TRIGGER movies_search_vector_trigger
+    BEFORE INSERT OR UPDATE OF title, genres ON movies
+    FOR EACH ROW EXECUTE FUNCTION movies_search_vector_update();
+
+-- Populate the column for the existing movies.
+UPDATE movies SET search_vector =
+    setweight(to_tsvector('simple', title), 'A') ||
+    setweight(to_tsvector('simple', array_to_string(genres, ' ')), 'B');
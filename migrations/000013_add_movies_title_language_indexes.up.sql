@@ -0,0 +1,8 @@
+-- Expression indexes for title searches in the most common text search configurations. Each index only helps queries which use exactly
+-- the same to_tsvector() expression, so there is one per configuration. The simple configuration is covered by movies_title_idx.
+CREATE INDEX IF NOT EXISTS movies_title_english_idx ON movies USING GIN (to_tsvector('english', title));
+CREATE INDEX IF NOT EXISTS movies_title_french_idx ON movies USING GIN (to_tsvector('french', title));
+CREATE INDEX IF NOT EXISTS movies_title_german_idx ON movies USING GIN (to_tsvector('german', title));
+CREATE INDEX IF NOT EXISTS movies_title_italian_idx ON movies USING GIN (to_tsvector('italian', title));
+CREATE INDEX IF NOT EXISTS movies_title_portuguese_idx ON movies USING GIN (to_tsvector('portuguese', title));
+CREATE INDEX IF NOT EXISTS movies_title_spanish_idx ON movies USING GIN (to_tsvector('spanish', title));
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code = 'reviews:write';
+ALTER TABLE movies DROP COLUMN IF EXISTS ratings_count;
+ALTER TABLE movies DROP COLUMN IF EXISTS average_rating;
+DROP TABLE IF EXISTS reviews;
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    rating integer NOT NULL,
+    body text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (movie_id, user_id),
+    CONSTRAINT reviews_rating_check CHECK (rating BETWEEN 1 AND 10)
+);
+
+CREATE INDEX IF NOT EXISTS reviews_user_id_idx ON reviews (user_id);
+
+-- The rating aggregates are stored on the movies table so that movies can be sorted by them.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS average_rating numeric(4, 2) NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS ratings_count integer NOT NULL DEFAULT 0;
+
+-- Add the permission for writing reviews, and give it to every existing user who can read movies.
+INSERT INTO permissions (code)
+VALUES
+    ('reviews:write');
+
+INSERT INTO users_permissions
+SELECT users_permissions.user_id, reviews_write.id
+FROM users_permissions
+INNER JOIN permissions ON permissions.id = users_permissions.permission_id AND permissions.code = 'movies:read'
+CROSS JOIN (SELECT id FROM permissions WHERE code = 'reviews:write') AS reviews_write;
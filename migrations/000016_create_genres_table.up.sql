@@ -0,0 +1,55 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    slug text NOT NULL UNIQUE,
+    name text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+-- Known alternative spellings of the same genre, keyed by their slug.
+CREATE TEMPORARY TABLE genre_aliases (
+    alias text PRIMARY KEY,
+    slug text NOT NULL
+);
+
+INSERT INTO genre_aliases (alias, slug)
+VALUES
+    ('science-fiction', 'sci-fi'),
+    ('scifi', 'sci-fi'),
+    ('sf', 'sci-fi'),
+    ('animated', 'animation'),
+    ('documentaries', 'documentary'),
+    ('rom-com', 'romcom');
+
+-- The genre_slug() function converts an existing genre value to its canonical slug: lowercase letters and digits separated by single
+-- hyphens (so "Sci-Fi", "sci fi" and "SCI-FI" all become "sci-fi"), mapped through the aliases above.
+CREATE FUNCTION pg_temp.genre_slug(value text) RETURNS text AS $$
+    SELECT COALESCE((SELECT genre_aliases.slug FROM genre_aliases WHERE genre_aliases.alias = s.slug), NULLIF(s.slug, ''), 'other')
+    FROM (SELECT trim(both '-' from regexp_replace(lower(value), '[^a-z0-9]+', '-', 'g')) AS slug) AS s
+$$ LANGUAGE sql;
+
+-- Create a genre for every canonical slug in use.
+INSERT INTO genres (slug, name)
+SELECT slug, initcap(slug)
+FROM (SELECT DISTINCT pg_temp.genre_slug(genre) AS slug FROM movies, unnest(movies.genres) AS genre) AS canonical
+ON CONFLICT (slug) DO NOTHING;
+
+-- Replace the genres of every movie with their canonical slugs, keeping them in their original order and removing any duplicates
+-- (for example, a movie with both "Sci-Fi" and "science fiction").
+UPDATE movies SET genres = ARRAY(
+    SELECT slug
+    FROM (
+        SELECT pg_temp.genre_slug(genre) AS slug, min(position) AS position
+        FROM unnest(movies.genres) WITH ORDINALITY AS g(genre, position)
+        GROUP BY 1
+    ) AS canonical
+    ORDER BY position
+);
+
+DROP FUNCTION pg_temp.genre_slug(text);
+DROP TABLE genre_aliases;
+
+-- Add the permission for managing genres.
+INSERT INTO permissions (code)
+VALUES
+    ('genres:write');
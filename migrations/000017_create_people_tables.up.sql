@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS people (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    birth_year integer,
+    bio text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS people_name_idx ON people USING GIN (to_tsvector('simple', name));
+
+-- A person can have more than one role on a movie (for example, as both director and writer), but only one credit for each role.
+CREATE TABLE IF NOT EXISTS movie_credits (
+    id bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    person_id bigint NOT NULL REFERENCES people ON DELETE CASCADE,
+    role text NOT NULL CHECK (role IN ('director', 'writer', 'actor')),
+    character text NOT NULL DEFAULT '',
+    billing_order integer NOT NULL DEFAULT 0 CHECK (billing_order >= 0),
+    UNIQUE (movie_id, person_id, role)
+);
+
+-- The unique constraint covers lookups by movie, and this index covers the person filter on the movie list.
+CREATE INDEX IF NOT EXISTS movie_credits_person_id_idx ON movie_credits (person_id);
+
+-- Add the permissions for people, and give people:read to every existing user who can read movies.
+INSERT INTO permissions (code)
+VALUES
+    ('people:read'),
+    ('people:write');
+
+INSERT INTO users_permissions
+SELECT users_permissions.user_id, people_read.id
+FROM users_permissions
+INNER JOIN permissions ON permissions.id = users_permissions.permission_id AND permissions.code = 'movies:read'
+CROSS JOIN (SELECT id FROM permissions WHERE code = 'people:read') AS people_read;
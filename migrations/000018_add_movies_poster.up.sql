@@ -0,0 +1,2 @@
+-- The storage key of the movie's original poster, or an empty string if it doesn't have one.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster text NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS movies_normalized_title_idx;
+DROP INDEX IF EXISTS movies_tmdb_id_key;
+DROP INDEX IF EXISTS movies_imdb_id_key;
+ALTER TABLE movies DROP COLUMN IF EXISTS tmdb_id;
+ALTER TABLE movies DROP COLUMN IF EXISTS imdb_id;
@@ -0,0 +1,11 @@
+-- Optional IDs in external catalogues. Empty strings and zeros mean that there is no ID, and are left out of the unique indexes. The
+-- indexes include movies in the trash, so that restoring a movie can never create a duplicate.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS imdb_id text NOT NULL DEFAULT '';
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS tmdb_id bigint NOT NULL DEFAULT 0;
+
+CREATE UNIQUE INDEX IF NOT EXISTS movies_imdb_id_key ON movies (imdb_id) WHERE imdb_id <> '';
+CREATE UNIQUE INDEX IF NOT EXISTS movies_tmdb_id_key ON movies (tmdb_id) WHERE tmdb_id <> 0;
+
+-- Supports the duplicate check when creating a movie, which matches on the year and normalized title.
+CREATE INDEX IF NOT EXISTS movies_normalized_title_idx ON movies (year, (regexp_replace(lower(title), '[^[:alnum:]]+', '', 'g')))
+WHERE deleted_at IS NULL;
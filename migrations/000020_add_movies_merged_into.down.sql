@@ -0,0 +1,8 @@
+DELETE FROM movie_revisions WHERE merged_from IS NOT NULL;
+DROP INDEX IF EXISTS movie_revisions_movie_id_idx;
+DROP INDEX IF EXISTS movie_revisions_merged_from_version_key;
+DROP INDEX IF EXISTS movie_revisions_movie_id_version_key;
+ALTER TABLE movie_revisions ADD PRIMARY KEY (movie_id, version);
+ALTER TABLE movie_revisions DROP COLUMN IF EXISTS merged_from;
+DROP INDEX IF EXISTS movies_merged_into_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS merged_into;
@@ -0,0 +1,11 @@
+-- A movie which has been merged into another one is kept as a redirect to it. The redirect is removed when the target movie is purged.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS merged_into bigint REFERENCES movies ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS movies_merged_into_idx ON movies (merged_into) WHERE merged_into IS NOT NULL;
+
+-- Revisions are moved to the target movie when two movies are merged, so the version numbers of a movie's revisions are no longer unique.
+-- The merged_from column records which movie a revision originally belonged to, and the version numbers are unique within each movie.
+ALTER TABLE movie_revisions ADD COLUMN IF NOT EXISTS merged_from bigint;
+ALTER TABLE movie_revisions DROP CONSTRAINT IF EXISTS movie_revisions_pkey;
+CREATE UNIQUE INDEX IF NOT EXISTS movie_revisions_movie_id_version_key ON movie_revisions (movie_id, version) WHERE merged_from IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS movie_revisions_merged_from_version_key ON movie_revisions (merged_from, version) WHERE merged_from IS NOT NULL;
+CREATE INDEX IF NOT EXISTS movie_revisions_movie_id_idx ON movie_revisions (movie_id);
@@ -0,0 +1,23 @@
+-- The users who created each movie and last changed it.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS created_by bigint REFERENCES users ON DELETE SET NULL;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS updated_by bigint REFERENCES users ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS movies_created_by_idx ON movies (created_by) WHERE deleted_at IS NULL;
+
+-- Fill in the columns for existing movies from their first and latest revisions.
+UPDATE movies
+SET created_by = (
+        SELECT user_id FROM movie_revisions
+        WHERE movie_revisions.movie_id = movies.id AND movie_revisions.merged_from IS NULL
+        ORDER BY version ASC LIMIT 1
+    ),
+    updated_by = (
+        SELECT user_id FROM movie_revisions
+        WHERE movie_revisions.movie_id = movies.id AND movie_revisions.merged_from IS NULL
+        ORDER BY version DESC LIMIT 1
+    );
+
+-- Add the permission for changing only the movies which a user created.
+INSERT INTO permissions (code)
+VALUES
+    ('movies:write:own');
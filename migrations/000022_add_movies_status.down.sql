@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code = 'movies:publish';
+DROP INDEX IF EXISTS movies_status_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS publish_at;
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_status_check;
+ALTER TABLE movies DROP COLUMN IF EXISTS status;
@@ -0,0 +1,20 @@
+-- The publishing workflow status of each movie. Existing movies are already public, so they are published; new movies start as drafts.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'published';
+ALTER TABLE movies ALTER COLUMN status SET DEFAULT 'draft';
+ALTER TABLE movies ADD CONSTRAINT movies_status_check CHECK (status IN ('draft', 'in_review', 'published', 'archived'));
+
+-- A published movie with a publish_at time in the future is scheduled, and isn't visible to everyone until then.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS publish_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS movies_status_idx ON movies (status, publish_at) WHERE deleted_at IS NULL;
+
+-- Add the permission for publishing movies, and give it to the users who can already change movies so that they can keep doing so.
+INSERT INTO permissions (code)
+VALUES
+    ('movies:publish');
+
+INSERT INTO users_permissions
+SELECT users_permissions.user_id, movies_publish.id
+FROM users_permissions
+INNER JOIN permissions ON permissions.id = users_permissions.permission_id AND permissions.code = 'movies:write'
+CROSS JOIN (SELECT id FROM permissions WHERE code = 'movies:publish') AS movies_publish;
@@ -0,0 +1,2 @@
+ALTER TABLE movie_revisions DROP COLUMN IF EXISTS tmdb_id;
+ALTER TABLE movie_revisions DROP COLUMN IF EXISTS imdb_id;
@@ -0,0 +1,10 @@
+-- Record the external IDs in each revision, so that changes to them show up in diffs and can be reverted.
+ALTER TABLE movie_revisions ADD COLUMN IF NOT EXISTS imdb_id text NOT NULL DEFAULT '';
+ALTER TABLE movie_revisions ADD COLUMN IF NOT EXISTS tmdb_id bigint NOT NULL DEFAULT 0;
+
+-- The external IDs weren't tracked before, so assume that each movie's existing revisions had its current IDs. This stops a revert to an
+-- old revision from clearing them. Revisions from merged movies are left empty, as the merged movies' IDs have been cleared.
+UPDATE movie_revisions
+SET imdb_id = movies.imdb_id, tmdb_id = movies.tmdb_id
+FROM movies
+WHERE movie_revisions.movie_id = movies.id AND movie_revisions.merged_from IS NULL;